@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/internal"
+)
+
+// fakefeed serves App Store customer reviews RSS pages built from the JSONL
+// files under data/reviews (JSON and Atom XML), so the server can run offline.
+// The store is opened read-only: the server may be writing to the same files.
+//
+//	go run ./cmd/fakefeed -addr :8081
+//	# config/apps.json: "feedBaseUrl": "http://localhost:8081"
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	addr := flag.String("addr", ":8081", "listen address")
+	dataDir := flag.String("data", "data", "data directory (reviews/*.jsonl)")
+	pageSize := flag.Int("page-size", 50, "entries per page")
+	maxPages := flag.Int("pages", 10, "max page depth")
+	emptyRate := flag.Float64("empty-rate", 0, "fraction of pages served empty (0..1)")
+	corruptRate := flag.Float64("corrupt-rate", 0, "fraction of JSON pages served truncated (0..1)")
+	errorRate := flag.Float64("error-rate", 0, "fraction of requests answered with 503 (0..1)")
+	timeoutRate := flag.Float64("timeout-rate", 0, "fraction of requests that hang (0..1)")
+	hang := flag.Duration("hang", 30*time.Second, "how long a hanging request stalls")
+	throttleRate := flag.Float64("throttle-rate", 0, "fraction of requests answered with 429 (0..1)")
+	retryAfter := flag.Duration("retry-after", 5*time.Second, "Retry-After sent with injected 429s")
+	seed := flag.Int64("seed", 1, "random seed for fault injection")
+	flag.Parse()
+
+	st, err := internal.OpenFileStoreReadOnly(*dataDir)
+	if err != nil {
+		log.Fatalf("init store: %v", err)
+	}
+
+	feed := internal.NewFakeFeed(st, internal.FakeFeedOptions{
+		PageSize:     *pageSize,
+		MaxPages:     *maxPages,
+		EmptyRate:    *emptyRate,
+		CorruptRate:  *corruptRate,
+		ErrorRate:    *errorRate,
+		TimeoutRate:  *timeoutRate,
+		Hang:         *hang,
+		ThrottleRate: *throttleRate,
+		RetryAfter:   *retryAfter,
+		Seed:         *seed,
+	})
+
+	log.Printf("fake feed listening on %s (data=%s)", *addr, *dataDir)
+	if err := http.ListenAndServe(*addr, feed); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
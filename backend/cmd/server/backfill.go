@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"backend/internal"
+)
+
+// runBackfill: server backfill [-app ID] [-country CC]
+// Runs in the foreground with the server stopped (data/ is locked; use POST
+// /backfill while it runs); Ctrl-C stops it and the next run resumes.
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	appID := fs.String("app", "", "appId to backfill (default: every configured app)")
+	country := fs.String("country", "", "storefront (default: every configured storefront)")
+	_ = fs.Parse(args)
+
+	cfg, st := openStore()
+	defer st.Close()
+	apps := []internal.AppConfig{}
+	for _, a := range cfg.Apps {
+		if (*appID == "" || a.AppID == *appID) && (*country == "" || a.Country == *country) {
+			apps = append(apps, a)
+		}
+	}
+	if len(apps) == 0 {
+		log.Fatalf("backfill: no configured app matches app=%q country=%q", *appID, *country)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mgr := internal.NewManager(cfg, st)
+	failed := false
+	for _, app := range apps {
+		res, err := mgr.Backfill(ctx, app, nil)
+		if err != nil {
+			log.Printf("[backfill %s] %s at page %d/%d: %v", res.ID, res.State, res.Page, res.Pages, err)
+			failed = true
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if failed {
+		st.Close()
+		os.Exit(1)
+	}
+}
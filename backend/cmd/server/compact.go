@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"backend/internal"
+)
+
+// runCompact: server compact [-app ID] [-country CC]
+// Rewrites the stores in place, so it refuses to run alongside the server
+// (the scheduled compaction, compactIntervalHours, runs inside it instead).
+func runCompact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	appID := fs.String("app", "", "appId to compact (default: every configured app)")
+	country := fs.String("country", "", "storefront (default: every configured storefront)")
+	_ = fs.Parse(args)
+
+	cfg, st := openStore()
+	defer st.Close()
+	apps := []internal.AppConfig{}
+	for _, a := range cfg.Apps {
+		if (*appID == "" || a.AppID == *appID) && (*country == "" || a.Country == *country) {
+			apps = append(apps, a)
+		}
+	}
+	if len(apps) == 0 {
+		log.Fatalf("compact: no configured app matches app=%q country=%q", *appID, *country)
+	}
+
+	mgr := internal.NewManager(cfg, st)
+	failed := false
+	for _, app := range apps {
+		res, err := mgr.Compact(context.Background(), app)
+		if err != nil {
+			log.Printf("[compact %s-%s] %v", app.AppID, app.Country, err)
+			failed = true
+			continue
+		}
+		log.Printf("[compact %s] %d rows: %d kept, %d duplicates, %d expired, %d quarantined, %d segments gzipped",
+			res.ID, res.Rows, res.Kept, res.Duplicates, res.Expired, res.Quarantined, res.Compressed)
+	}
+	if failed {
+		st.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"backend/internal"
+)
+
+// runImport: server import -app ID -country CC [-format csv|jsonl] [-map field=column,...] FILE
+// Takes the lock of data/ like the server, so it refuses to run alongside it:
+// POST /admin/import imports into a running server.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	appID := fs.String("app", "", "appId the reviews belong to (required)")
+	country := fs.String("country", "", "storefront the reviews belong to (required)")
+	format := fs.String("format", "", "csv or jsonl (default: from the file extension)")
+	mapping := fs.String("map", "", "Review field=export column, comma separated (e.g. id=review_id,rating=stars)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *appID == "" || *country == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+	if *format == "" {
+		*format = internal.ImportJSONL
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			*format = internal.ImportCSV
+		}
+	}
+	m, err := internal.ParseImportMapping(*mapping)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	defer f.Close()
+
+	cfg, st := openStore()
+	defer st.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	mgr := internal.NewManager(cfg, st)
+	res, err := mgr.Import(ctx, f, internal.ImportOptions{AppID: *appID, Country: *country, Format: *format, Mapping: m})
+	for _, e := range res.Errors {
+		log.Printf("[import %s] line %d rejected: %s", res.ID, e.Line, e.Error)
+	}
+	if res.Rejected > len(res.Errors) {
+		log.Printf("[import %s] ... and %d more rejected rows", res.ID, res.Rejected-len(res.Errors))
+	}
+	log.Printf("[import %s] %d rows: %d accepted, %d duplicates, %d rejected", res.ID, res.Rows, res.Accepted, res.Duplicates, res.Rejected)
+	if err != nil {
+		st.Close()
+		log.Fatalf("import: %v", err)
+	}
+}
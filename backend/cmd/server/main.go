@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -23,22 +25,76 @@ func loadConfig() (*internal.Config, error) {
 	return internal.ParseConfig(f)
 }
 
+const usage = `usage: server [command] [flags]
+
+commands:
+  serve      run the HTTP server and the pollers (default)
+  backfill   walk every feed page of one or all configured apps
+  compact    dedupe the stored reviews and apply the retention window
+  backup     write a tar.gz snapshot of the store
+  restore    replace the store with a snapshot (server stopped)
+  import     load historical reviews from a CSV or JSONL export
+`
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		serve()
+	case "backfill":
+		runBackfill(args)
+	case "compact":
+		runCompact(args)
+	case "backup":
+		runBackup(args)
+	case "restore":
+		runRestore(args)
+	case "import":
+		runImport(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// dataLock is held until the process exits
+var dataLock *internal.DataLock
+
+// lockData takes the lock of data/: one server or CLI at a time writes there
+func lockData() {
 	if err := os.MkdirAll(filepath.Join("data", "reviews"), 0o755); err != nil {
 		log.Fatalf("creating data/reviews: %v", err)
 	}
+	l, err := internal.LockDataDir("data")
+	if err != nil {
+		log.Fatalf("locking data/: %v: stop the server (or the other command) first", err)
+	}
+	dataLock = l
+}
 
+// openStore loads the config and opens the configured store under data/,
+// holding its lock
+func openStore() (*internal.Config, internal.ReviewStore) {
 	cfg, err := loadConfig()
 	if err != nil {
 		log.Fatalf("load config: %v", err)
 	}
+	lockData()
 
-	st, err := internal.NewFileStore("data")
+	st, err := internal.OpenStore(cfg, "data")
 	if err != nil {
 		log.Fatalf("init store: %v", err)
 	}
+	return cfg, st
+}
+
+func serve() {
+	cfg, st := openStore()
 
 	mgr := internal.NewManager(cfg, st)
 	mgr.Start()
@@ -77,4 +133,10 @@ func main() {
 	} else {
 		log.Println("server stopped cleanly")
 	}
+
+	// 3) Close the store (flushes the SQLite WAL)
+	if err := st.Close(); err != nil {
+		log.Printf("store close error: %v", err)
+	}
+	dataLock.Unlock()
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"backend/internal"
+)
+
+// runBackup: server backup [-dir DIR]
+// Refuses to run alongside the server (data/ is locked): use POST
+// /admin/snapshot then, only the server can pause its own writes.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := fs.String("dir", "", "where to write the archive (default: backupDir from the config)")
+	_ = fs.Parse(args)
+
+	cfg, st := openStore()
+	defer st.Close()
+	if *dir == "" {
+		*dir = cfg.BackupDir
+	}
+	path, man, err := internal.WriteSnapshot(st, *dir)
+	if err != nil {
+		st.Close()
+		log.Fatalf("backup: %v", err)
+	}
+	log.Printf("backup: wrote %s (%s store, %d files)", path, man.Backend, len(man.Files))
+}
+
+// runRestore: server restore ARCHIVE
+// The server must be stopped. The current data is moved aside, not deleted.
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: server restore snapshot-XXXX.tar.gz\n"))
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("load config: %v", err)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("restore: %v", err)
+	}
+	defer f.Close()
+	lockData()
+	man, err := internal.RestoreSnapshot(f, cfg, "data")
+	if err != nil {
+		log.Fatalf("restore: %v", err)
+	}
+	log.Printf("restore: %d files from the %s snapshot of %s, checksums ok",
+		len(man.Files), man.Backend, man.CreatedAt.Format("2006-01-02 15:04:05Z"))
+
+	// open it once, so a broken restore shows up now and not at the next start
+	st, err := internal.OpenStore(cfg, "data")
+	if err != nil {
+		log.Fatalf("restore: the restored store does not open: %v", err)
+	}
+	st.Close()
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Text analysis for the search index: lowercase, fold diacritics, split on
+// anything that isn't a letter or a digit, stem. Documents and queries go
+// through the same steps, so "Aggiornàmenti" finds "aggiornamento".
+
+// Stemming languages
+const (
+	LangEnglish = "en"
+	LangItalian = "it"
+)
+
+// langFor: storefronts whose reviews are (mostly) Italian; English elsewhere
+func langFor(country string) string {
+	switch strings.ToLower(country) {
+	case "it", "sm", "va":
+		return LangItalian
+	}
+	return LangEnglish
+}
+
+// foldTable: accented Latin letters -> plain ASCII
+var foldTable = func() map[rune]string {
+	m := map[rune]string{}
+	for _, p := range []struct{ from, to string }{
+		{"àáâãäåāăą", "a"}, {"çćĉċč", "c"}, {"ďđð", "d"}, {"èéêëēĕėęě", "e"},
+		{"ĝğġģ", "g"}, {"ĥħ", "h"}, {"ìíîïĩīĭįı", "i"}, {"ĵ", "j"}, {"ķ", "k"},
+		{"ĺļľŀł", "l"}, {"ñńņňŉ", "n"}, {"òóôõöøōŏő", "o"}, {"ŕŗř", "r"},
+		{"śŝşš", "s"}, {"ţťŧ", "t"}, {"ùúûüũūŭůűų", "u"}, {"ŵ", "w"},
+		{"ýÿŷ", "y"}, {"źżž", "z"},
+	} {
+		for _, r := range p.from {
+			m[r] = p.to
+		}
+	}
+	m['æ'], m['œ'], m['ß'], m['þ'] = "ae", "oe", "ss", "th"
+	return m
+}()
+
+// token: a term of a text and where it is (byte offsets in the original)
+type token struct {
+	term       string // folded and stemmed
+	start, end int
+}
+
+// tokenize splits text into stemmed terms, in order
+func tokenize(text, lang string) []token {
+	out := []token{}
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			out = append(out, token{term: stem(b.String(), lang), start: start, end: end})
+			b.Reset()
+			start = -1
+		}
+	}
+	for i, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush(i)
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		r = unicode.ToLower(r)
+		if f, ok := foldTable[r]; ok {
+			b.WriteString(f)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	flush(len(text))
+	return out
+}
+
+// terms: just the terms of tokenize
+func terms(text, lang string) []string {
+	toks := tokenize(text, lang)
+	out := make([]string, len(toks))
+	for i, t := range toks {
+		out[i] = t.term
+	}
+	return out
+}
+
+func stem(w, lang string) string {
+	if utf8.RuneCountInString(w) != len(w) {
+		return w // non-Latin script: left alone
+	}
+	if lang == LangItalian {
+		return stemItalian(w)
+	}
+	return stemEnglish(w)
+}
+
+func isVowel(c byte) bool { return strings.IndexByte("aeiouy", c) >= 0 }
+
+func hasVowel(w string) bool {
+	for i := 0; i < len(w); i++ {
+		if isVowel(w[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// stemEnglish is a light stemmer: plurals, -ed/-ing, a few derivational
+// suffixes and the final e, enough for "crashes", "crashed", "crashing" and
+// "crash" to meet (it doesn't try to be Porter)
+func stemEnglish(w string) string {
+	if len(w) <= 3 {
+		return w
+	}
+	// plurals
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies"):
+		if len(w) > 4 {
+			w = w[:len(w)-3] + "y"
+		} else {
+			w = w[:len(w)-1]
+		}
+	case strings.HasSuffix(w, "ches"), strings.HasSuffix(w, "shes"),
+		strings.HasSuffix(w, "xes"), strings.HasSuffix(w, "zes"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"), strings.HasSuffix(w, "is"):
+	case strings.HasSuffix(w, "s"):
+		w = w[:len(w)-1]
+	}
+	// -eed / -ed / -ing
+	switch {
+	case strings.HasSuffix(w, "eed"):
+		if len(w) > 4 {
+			w = w[:len(w)-1]
+		}
+	case strings.HasSuffix(w, "ed"), strings.HasSuffix(w, "ing"):
+		suf := 2
+		if strings.HasSuffix(w, "ing") {
+			suf = 3
+		}
+		if base := w[:len(w)-suf]; len(base) >= 3 && hasVowel(base) {
+			w = base
+			// stopped -> stopp -> stop
+			if n := len(w); w[n-1] == w[n-2] && strings.IndexByte("bdfgmnprt", w[n-1]) >= 0 {
+				w = w[:n-1]
+			}
+		}
+	}
+	// a few derivational suffixes
+	for _, s := range []struct {
+		suf, repl string
+		keep      int // letters that must be left
+	}{{"iness", "y", 3}, {"ness", "", 4}, {"ment", "", 4}, {"fully", "", 3}, {"ful", "", 3}, {"ly", "", 4}} {
+		if strings.HasSuffix(w, s.suf) && len(w)-len(s.suf) >= s.keep {
+			w = w[:len(w)-len(s.suf)] + s.repl
+			break
+		}
+	}
+	// update / updates / updated -> updat
+	if len(w) > 4 && w[len(w)-1] == 'e' {
+		w = w[:len(w)-1]
+	}
+	return w
+}
+
+// stemItalian is a light stemmer (the one of Savoy, as in Lucene): the -mente
+// of adverbs and the final vowel of gender and number, so "aggiornamento",
+// "aggiornamenti" and "aggiornamenta" meet
+func stemItalian(w string) string {
+	if strings.HasSuffix(w, "mente") && len(w) > 8 {
+		w = w[:len(w)-5]
+	}
+	n := len(w)
+	if n < 6 {
+		return w
+	}
+	switch w[n-1] {
+	case 'e':
+		if w[n-2] == 'i' || w[n-2] == 'h' {
+			return w[:n-2]
+		}
+		return w[:n-1]
+	case 'i':
+		if w[n-2] == 'h' || w[n-2] == 'i' {
+			return w[:n-2]
+		}
+		return w[:n-1]
+	case 'a', 'o':
+		if w[n-2] == 'i' {
+			return w[:n-2]
+		}
+		return w[:n-1]
+	}
+	return w
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rating-drop detection: after a poll that stored something, the last
+// windowHours of reviews are compared with the baselineDays before them, cut
+// into windows of the same length. The window is anomalous when its average
+// rating is zThreshold standard deviations below the average of the baseline
+// windows, or its 1-star count that many above theirs. The baseline comes
+// from the store, so it survives restarts and needs no state of its own.
+
+// Defaults of AnomalyConfig
+const (
+	defaultAnomalyWindowHours  = 24
+	defaultAnomalyBaselineDays = 28
+	defaultAnomalyZThreshold   = 3
+	defaultAnomalyMinReviews   = 5
+	defaultAnomalySamples      = 5
+)
+
+// anomalyMinBaseline: baseline windows with reviews needed to judge the average
+const anomalyMinBaseline = 7
+
+// floors of the standard deviations, so a very steady baseline doesn't turn
+// any wobble into an alert
+const (
+	minAverageStddev = 0.25
+	minOneStarStddev = 1.0
+)
+
+func (a *AnomalyConfig) applyDefaults() error {
+	if a.WindowHours < 0 || a.BaselineDays < 0 || a.ZThreshold < 0 || a.MinReviews < 0 || a.Samples < 0 {
+		return fmt.Errorf("anomaly: values must not be negative")
+	}
+	if a.WindowHours == 0 {
+		a.WindowHours = defaultAnomalyWindowHours
+	}
+	if a.BaselineDays == 0 {
+		a.BaselineDays = defaultAnomalyBaselineDays
+	}
+	if a.ZThreshold == 0 {
+		a.ZThreshold = defaultAnomalyZThreshold
+	}
+	if a.MinReviews == 0 {
+		a.MinReviews = defaultAnomalyMinReviews
+	}
+	if a.Samples == 0 {
+		a.Samples = defaultAnomalySamples
+	}
+	if a.BaselineDays*24 < a.WindowHours*anomalyMinBaseline {
+		return fmt.Errorf("anomaly: baselineDays must hold at least %d windows of windowHours", anomalyMinBaseline)
+	}
+	return nil
+}
+
+// AnomalyWindow: the reviews of one window
+type AnomalyWindow struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Count   int       `json:"count"`
+	Average float64   `json:"average"`
+	OneStar int       `json:"oneStar"`
+	sum     int
+}
+
+// AnomalyBaseline: mean and standard deviation over the baseline windows
+type AnomalyBaseline struct {
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	Windows       int       `json:"windows"`
+	Average       float64   `json:"average"` // of the window averages
+	AverageStddev float64   `json:"averageStddev"`
+	OneStar       float64   `json:"oneStar"` // 1-star reviews per window
+	OneStarStddev float64   `json:"oneStarStddev"`
+	AverageZScore float64   `json:"averageZScore"`
+	OneStarZScore float64   `json:"oneStarZScore"`
+}
+
+// AnomalyAlert is the rating.anomaly webhook event
+type AnomalyAlert struct {
+	Event     string          `json:"event"`
+	ID        string          `json:"id"` // appId-country
+	AppID     string          `json:"appId"`
+	Country   string          `json:"country"`
+	Timestamp string          `json:"timestamp"`
+	Reasons   []string        `json:"reasons"`
+	Window    AnomalyWindow   `json:"window"`
+	Baseline  AnomalyBaseline `json:"baseline"`
+	Samples   []Review        `json:"samples"` // lowest ratings of the window first
+}
+
+// detectAnomaly checks the last window of an app; nil if it looks normal
+func (m *Manager) detectAnomaly(app AppConfig, now time.Time) (*AnomalyAlert, error) {
+	ac := m.cfg.Anomaly
+	win := time.Duration(ac.WindowHours) * time.Hour
+	n := ac.BaselineDays * 24 / ac.WindowHours // baseline windows
+	from := now.Add(-win * time.Duration(n+1))
+	revs, err := m.store.ReadWindow(app.AppID, app.Country, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// windows[0] is the recent one, then older and older
+	windows := make([]AnomalyWindow, n+1)
+	for i := range windows {
+		windows[i].To = now.Add(-win * time.Duration(i))
+		windows[i].From = windows[i].To.Add(-win)
+	}
+	recent := []Review{}
+	for _, r := range revs {
+		age := now.Sub(r.SubmittedAt)
+		if r.Removed() || r.Rating < 1 || r.Rating > 5 || age < 0 {
+			continue
+		}
+		i := int(age / win)
+		if i > n {
+			continue
+		}
+		w := &windows[i]
+		w.Count++
+		w.sum += r.Rating
+		if r.Rating == 1 {
+			w.OneStar++
+		}
+		if i == 0 {
+			recent = append(recent, r)
+		}
+	}
+	for i := range windows {
+		if windows[i].Count > 0 {
+			windows[i].Average = round2(float64(windows[i].sum) / float64(windows[i].Count))
+		}
+	}
+	cur := windows[0]
+	if cur.Count < ac.MinReviews {
+		return nil, nil
+	}
+
+	base := AnomalyBaseline{From: windows[n].From, To: windows[1].To, Windows: n}
+	avgs, ones := []float64{}, []float64{}
+	for _, w := range windows[1:] {
+		ones = append(ones, float64(w.OneStar))
+		if w.Count > 0 {
+			avgs = append(avgs, float64(w.sum)/float64(w.Count))
+		}
+	}
+	if len(avgs) < anomalyMinBaseline {
+		return nil, nil // too little history to tell
+	}
+	base.Average, base.AverageStddev = meanStddev(avgs)
+	base.OneStar, base.OneStarStddev = meanStddev(ones)
+	base.AverageZScore = round2((float64(cur.sum)/float64(cur.Count) - base.Average) / math.Max(base.AverageStddev, minAverageStddev))
+	base.OneStarZScore = round2((float64(cur.OneStar) - base.OneStar) / math.Max(base.OneStarStddev, minOneStarStddev))
+	base.Average, base.AverageStddev = round2(base.Average), round2(base.AverageStddev)
+	base.OneStar, base.OneStarStddev = round2(base.OneStar), round2(base.OneStarStddev)
+
+	reasons := []string{}
+	if base.AverageZScore <= -ac.ZThreshold {
+		reasons = append(reasons, fmt.Sprintf("average rating %.2f vs %.2f in the baseline (z=%.1f)", cur.Average, base.Average, base.AverageZScore))
+	}
+	if base.OneStarZScore >= ac.ZThreshold {
+		reasons = append(reasons, fmt.Sprintf("%d 1-star reviews vs %.1f per window in the baseline (z=%.1f)", cur.OneStar, base.OneStar, base.OneStarZScore))
+	}
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(recent, func(i, j int) bool {
+		if recent[i].Rating != recent[j].Rating {
+			return recent[i].Rating < recent[j].Rating
+		}
+		return recent[i].SubmittedAt.After(recent[j].SubmittedAt)
+	})
+	return &AnomalyAlert{
+		Event:     EventRatingAnomaly,
+		ID:        storeKey(app.AppID, app.Country),
+		AppID:     app.AppID,
+		Country:   app.Country,
+		Timestamp: now.Format(time.RFC3339),
+		Reasons:   reasons,
+		Window:    cur,
+		Baseline:  base,
+		Samples:   recent[:min(ac.Samples, len(recent))],
+	}, nil
+}
+
+// checkAnomaly runs detectAnomaly after a poll and queues the alert, at most
+// once per window for an app
+func (m *Manager) checkAnomaly(app AppConfig) {
+	if m.cfg.Anomaly.Disabled {
+		return
+	}
+	k := storeKey(app.AppID, app.Country)
+	now := time.Now().UTC().Truncate(time.Second)
+	win := time.Duration(m.cfg.Anomaly.WindowHours) * time.Hour
+	m.mu.Lock()
+	last := m.lastAlert[k]
+	m.mu.Unlock()
+	if now.Sub(last) < win {
+		return
+	}
+
+	a, err := m.detectAnomaly(app, now)
+	if err != nil {
+		log.Printf("[anomaly %s] %v", k, err)
+		return
+	}
+	if a == nil {
+		return
+	}
+	m.mu.Lock()
+	m.lastAlert[k] = now
+	m.mu.Unlock()
+	log.Printf("[anomaly %s] last %dh: %s", k, m.cfg.Anomaly.WindowHours, strings.Join(a.Reasons, "; "))
+	// only to subscriptions: webhookUrl gets the failure payload alone
+	for _, sub := range m.cfg.Webhooks {
+		if sub.wants(EventRatingAnomaly, app.AppID, app.Country) {
+			m.enqueueWebhook(sub.URL, EventRatingAnomaly+" "+k, a)
+		}
+	}
+}
+
+func meanStddev(xs []float64) (mean, stddev float64) {
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	for _, x := range xs {
+		stddev += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(xs)))
+}
+
+func round2(x float64) float64 { return math.Round(x*100) / 100 }
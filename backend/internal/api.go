@@ -2,14 +2,32 @@ package internal
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
-func BuildMux(cfg *Config, st *FileStore, mgr *Manager) *http.ServeMux {
+// maxImportBytes caps the body of POST /admin/import
+const maxImportBytes = 64 << 20
+
+// adminTimeout replaces the server's read/write timeouts (seconds, sized for
+// the read API) on /admin routes: a 64MB upload or a big snapshot takes longer
+const adminTimeout = 10 * time.Minute
+
+// GET /search returns defaultSearchLimit results unless ?limit= (up to maxSearchLimit)
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func BuildMux(cfg *Config, st ReviewStore, mgr *Manager) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
@@ -24,76 +42,397 @@ func BuildMux(cfg *Config, st *FileStore, mgr *Manager) *http.ServeMux {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "appId and country are required"})
 			return
 		}
-		go mgr.PollOnce(context.Background(), AppConfig{AppID: appID, Country: country})
+		if country == AnyCountry {
+			countries := mgr.Countries(appID)
+			if len(countries) == 0 {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configured storefronts for appId"})
+				return
+			}
+			for _, c := range countries {
+				app, _ := mgr.App(appID, c)
+				go mgr.PollOnce(context.Background(), app)
+			}
+			writeJSON(w, http.StatusAccepted, map[string]any{"status": "poll started", "countries": countries})
+			return
+		}
+		app, ok := mgr.App(appID, country)
+		if !ok {
+			// not in config: poll it anyway with the default source
+			if err := validKey(appID, country); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			app = AppConfig{AppID: appID, Country: country}
+		}
+		go mgr.PollOnce(context.Background(), app)
 		writeJSON(w, http.StatusAccepted, map[string]string{"status": "poll started"})
 	})
-	mux.HandleFunc("/reviews", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/backfill", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, mgr.BackfillStatuses())
+		case http.MethodPost:
+			// no appId => every configured app; no country => every storefront
+			appID := r.URL.Query().Get("appId")
+			country := r.URL.Query().Get("country")
+			apps := []AppConfig{}
+			for _, a := range mgr.Apps() {
+				if (appID == "" || a.AppID == appID) && (country == "" || country == AnyCountry || a.Country == country) {
+					apps = append(apps, a)
+				}
+			}
+			if len(apps) == 0 {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configured app matches"})
+				return
+			}
+			writeJSON(w, http.StatusAccepted, mgr.StartBackfill(apps))
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or POST"})
+		}
+	})
+	mux.HandleFunc("POST /admin/snapshot", adminOnly(cfg, func(w http.ResponseWriter, r *http.Request) {
+		extendDeadlines(w, adminTimeout)
+		// writes are paused while the store files are copied
+		path, man, err := WriteSnapshot(st, cfg.BackupDir)
+		if err != nil {
+			log.Printf("snapshot error: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "snapshot failed"})
+			return
+		}
+		log.Printf("[snapshot] %s (%d files)", path, len(man.Files))
+		writeJSON(w, http.StatusCreated, map[string]any{"path": path, "manifest": man})
+	}))
+	mux.HandleFunc("POST /admin/import", adminOnly(cfg, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		opt := ImportOptions{AppID: q.Get("appId"), Country: q.Get("country"), Format: q.Get("format")}
+		if opt.Format == "" {
+			opt.Format = ImportJSONL
+			if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+				opt.Format = ImportCSV
+			}
+		}
+		mapping, err := ParseImportMapping(q.Get("map"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		opt.Mapping = mapping
+		if opt.AppID == "" || opt.Country == "" || opt.Country == AnyCountry {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "appId and a single country are required"})
+			return
+		}
+		if err := validKey(opt.AppID, strings.ToLower(strings.TrimSpace(opt.Country))); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if opt.Format != ImportCSV && opt.Format != ImportJSONL {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be csv or jsonl"})
+			return
+		}
+		extendDeadlines(w, adminTimeout)
+		body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+		res, err := mgr.Import(r.Context(), body, opt)
+		if err != nil {
+			log.Printf("[import %s] %v", res.ID, err)
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "result": res})
+			return
+		}
+		log.Printf("[import %s] %d rows: %d accepted, %d duplicates, %d rejected", res.ID, res.Rows, res.Accepted, res.Duplicates, res.Rejected)
+		writeJSON(w, http.StatusOK, res)
+	}))
+	mux.HandleFunc("GET /reviews/{id}/history", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
 		appID := r.URL.Query().Get("appId")
 		country := r.URL.Query().Get("country")
 		if appID == "" || country == "" {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "appId and country are required"})
 			return
 		}
-		hours := 48
-		if hs := r.URL.Query().Get("hours"); hs != "" {
-			if n, err := strconv.Atoi(hs); err == nil && n > 0 && n <= 24*90 {
-				hours = n
+		countries := []string{country}
+		if country == AnyCountry {
+			countries = mgr.Countries(appID)
+		}
+		revs := []Review{}
+		for _, c := range countries {
+			h, err := st.History(appID, c, id)
+			if err != nil {
+				log.Printf("history error: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+				return
+			}
+			if len(h) > 0 {
+				revs = h
+				country = c
+				break
+			}
+		}
+		if len(revs) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "review not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id": id, "appId": appID, "country": country,
+			"count":     len(revs),
+			"revisions": revs,
+		})
+	})
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		sr, ok := st.(Searcher)
+		if !ok {
+			writeJSON(w, http.StatusNotImplemented, map[string]string{"error": "store does not support search"})
+			return
+		}
+		appID := r.URL.Query().Get("appId")
+		if appID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "appId is required"})
+			return
+		}
+		q, err := ParseSearchQuery(r.URL.Query().Get("q"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "q: " + err.Error()})
+			return
+		}
+		limit := defaultSearchLimit
+		if ls := r.URL.Query().Get("limit"); ls != "" {
+			n, err := strconv.Atoi(ls)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be 1.." + strconv.Itoa(maxSearchLimit)})
+				return
+			}
+			limit = n
+		}
+		// every configured storefront unless country is given
+		country := r.URL.Query().Get("country")
+		if country == "" {
+			country = AnyCountry
+		}
+		countries := []string{country}
+		if country == AnyCountry {
+			countries = mgr.Countries(appID)
+			if len(countries) == 0 {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configured storefronts for appId"})
+				return
 			}
 		}
 
-		// NEW: minRating (default 0 => nessun filtro; altrimenti clamp 1..5)
-		minRating := 0
-		if mr := r.URL.Query().Get("minRating"); mr != "" {
-			if n, err := strconv.Atoi(mr); err == nil {
-				if n < 1 {
-					n = 1
-				}
-				if n > 5 {
-					n = 5
-				}
-				minRating = n
+		hits := []SearchHit{}
+		total := 0
+		for _, c := range countries {
+			h, n, err := sr.Search(appID, c, q, limit)
+			if err != nil {
+				log.Printf("search error: %v", err)
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+				return
+			}
+			hits = append(hits, h...)
+			total += n
+		}
+		// scores of different storefronts are comparable enough to merge
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+		hits = hits[:min(limit, len(hits))]
+		writeJSON(w, http.StatusOK, map[string]any{
+			"appId": appID, "country": country, "countries": countries,
+			"q":       r.URL.Query().Get("q"),
+			"total":   total,
+			"count":   len(hits),
+			"results": hits,
+		})
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		appID := r.URL.Query().Get("appId")
+		if appID == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "appId is required"})
+			return
+		}
+		sq, err := parseStatsQuery(r.URL.Query(), time.Now().UTC())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		country := r.URL.Query().Get("country")
+		if country == "" {
+			country = AnyCountry
+		}
+		countries := []string{country}
+		if country == AnyCountry {
+			countries = mgr.Countries(appID)
+			if len(countries) == 0 {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configured storefronts for appId"})
+				return
+			}
+		}
+		revs, err := readWindowMulti(st, appID, countries, sq.From, sq.To)
+		if err != nil {
+			log.Printf("stats error: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"appId": appID, "country": country, "countries": countries,
+			"stats": ratingReport(revs, sq),
+		})
+	})
+	mux.HandleFunc("/reviews", func(w http.ResponseWriter, r *http.Request) {
+		appID := r.URL.Query().Get("appId")
+		country := r.URL.Query().Get("country")
+		if appID == "" || country == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "appId and country are required"})
+			return
+		}
+		now := time.Now().UTC()
+		f, err := parseReviewFilter(r.URL.Query(), now)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		// limit/cursor: optional pagination (no limit => the whole window)
+		limit := 0
+		if ls := r.URL.Query().Get("limit"); ls != "" {
+			n, err := strconv.Atoi(ls)
+			if err != nil || n < 1 || n > maxPageLimit {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be 1.." + strconv.Itoa(maxPageLimit)})
+				return
 			}
+			limit = n
+		}
+		var cursor *reviewCursor
+		if cs := r.URL.Query().Get("cursor"); cs != "" {
+			if limit == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "cursor requires limit"})
+				return
+			}
+			c, err := decodeCursor(cs)
+			if err == nil && c.Sort != f.Sort {
+				err = errors.New("cursor belongs to another sort")
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			cursor = &c
 		}
 
-		revs, err := st.ReadRecent(appID, country, time.Duration(hours)*time.Hour)
+		countries := []string{country}
+		if country == AnyCountry {
+			// aggregate view over every configured storefront
+			countries = mgr.Countries(appID)
+			if len(countries) == 0 {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "no configured storefronts for appId"})
+				return
+			}
+		}
+		revs, err := readWindowMulti(st, appID, countries, f.From, f.To)
 		if err != nil {
 			log.Printf("read recent error: %v", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
 			return
 		}
 
-		// NEW: filtro per rating minimo, se richiesto
-		if minRating >= 1 {
-			filtered := make([]Review, 0, len(revs))
-			for _, r := range revs {
-				if r.Rating >= minRating {
-					filtered = append(filtered, r)
-				}
-			}
-			revs = filtered
+		revs = f.apply(revs)
+		sortReviews(revs, f.Sort)
+		nextCursor := ""
+		if limit > 0 {
+			revs, nextCursor = paginate(revs, f.Sort, cursor, limit)
 		}
 
-		now := time.Now().UTC()
 		resp := map[string]any{
 			"appId": appID, "country": country,
-			"from":    now.Add(-time.Duration(hours) * time.Hour).Format(time.RFC3339),
-			"to":      now.Format(time.RFC3339),
+			"from":    f.From.Format(time.RFC3339),
+			"to":      f.To.Format(time.RFC3339),
 			"count":   len(revs),
 			"reviews": revs,
 		}
+		if limit > 0 {
+			resp["limit"] = limit
+			resp["nextCursor"] = nextCursor // "" on the last page
+		}
+		if country == AnyCountry {
+			resp["countries"] = countries
+		}
 		writeJSON(w, http.StatusOK, resp)
 	})
 	return mux
 }
 
+// AnyCountry in ?country= selects every configured storefront of the app
+const AnyCountry = "*"
+
+// readWindowMulti merges the reviews of several storefronts within from..to, newest first
+func readWindowMulti(st ReviewStore, appID string, countries []string, from, to time.Time) ([]Review, error) {
+	if len(countries) == 1 {
+		return st.ReadWindow(appID, countries[0], from, to)
+	}
+	out := []Review{}
+	for _, c := range countries {
+		revs, err := st.ReadWindow(appID, c, from, to)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, revs...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].SubmittedAt.After(out[j].SubmittedAt)
+	})
+	return out, nil
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// adminOnly guards the /admin routes. With adminToken set they need
+// "Authorization: Bearer <token>"; without it only local clients get in, and
+// not a web page open in a local browser (it sends Origin) nor whatever a
+// local reverse proxy forwards (X-Forwarded-For).
+func adminOnly(cfg *Config, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.AdminToken != "" {
+			tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(tok), []byte(cfg.AdminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "admin token required"})
+				return
+			}
+		} else if !isLoopback(r.RemoteAddr) || r.Header.Get("Origin") != "" || r.Header.Get("X-Forwarded-For") != "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin routes are local only (set adminToken to open them)"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// extendDeadlines gives the request d more to be read and answered
+func extendDeadlines(w http.ResponseWriter, d time.Duration) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(d)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Printf("extending read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		log.Printf("extending write deadline: %v", err)
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// WithCORS lets any origin call the API, except /admin: without CORS headers
+// a web page can't send it the token nor read its answers
 func WithCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
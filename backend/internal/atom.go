@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+// ---- types - Atom feed (same data as the JSON variant) ----
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Entry   []atomEntry `xml:"entry"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string `xml:"id"`
+	Updated string `xml:"updated"`
+	Title   string `xml:"title"`
+	Author  struct {
+		Name string `xml:"name"`
+		URI  string `xml:"uri"`
+	} `xml:"author"`
+	// Apple sends the body twice: type="text" and type="html"
+	Content   []atomContent `xml:"content"`
+	Link      []atomLink    `xml:"link"`
+	Rating    string        `xml:"http://itunes.apple.com/rss rating"`
+	Version   string        `xml:"http://itunes.apple.com/rss version"`
+	VoteSum   string        `xml:"http://itunes.apple.com/rss voteSum"`
+	VoteCount string        `xml:"http://itunes.apple.com/rss voteCount"`
+}
+
+// decodeAtomFeed maps Atom entries onto appleEntry, so both formats share
+// the same conversion to Review
+func decodeAtomFeed(body []byte) ([]appleEntry, error) {
+	var feed atomFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, err
+	}
+	out := make([]appleEntry, 0, len(feed.Entry))
+	for _, a := range feed.Entry {
+		var e appleEntry
+		e.ID.Label = a.ID
+		e.Updated.Label = a.Updated
+		e.Title.Label = a.Title
+		e.Author.Name.Label = a.Author.Name
+		e.Author.URI.Label = a.Author.URI
+		e.Rating.Label = a.Rating
+		e.Version.Label = a.Version
+		e.VoteSum.Label = a.VoteSum
+		e.VoteCount.Label = a.VoteCount
+		for i, c := range a.Content {
+			// prefer the plain text body, else whatever comes first
+			if i == 0 || c.Type == "text" {
+				e.Content.Label = c.Body
+				e.Content.Attributes.Type = c.Type
+			}
+		}
+		for _, l := range a.Link {
+			if l.Rel == "related" || e.Link.Attributes.Href == "" {
+				e.Link.Attributes.Rel = l.Rel
+				e.Link.Attributes.Href = l.Href
+			}
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// toAtomEntry is the XML counterpart of toAppleEntry (used by the fake feed)
+func toAtomEntry(r Review) atomEntry {
+	var a atomEntry
+	a.ID = r.ID
+	a.Updated = r.SubmittedAt.Format(time.RFC3339)
+	a.Title = r.Title
+	a.Author.Name = r.Author
+	a.Author.URI = r.AuthorURI
+	ct := r.ContentType
+	if ct == "" {
+		ct = "text"
+	}
+	a.Content = []atomContent{{Type: ct, Body: r.Content}}
+	if r.Link != "" {
+		a.Link = []atomLink{{Rel: "related", Href: r.Link}}
+	}
+	a.Rating = strconv.Itoa(r.Rating)
+	a.Version = r.AppVersion
+	a.VoteSum = strconv.Itoa(r.VoteSum)
+	a.VoteCount = strconv.Itoa(r.VoteCount)
+	return a
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Backfill job states
+const (
+	BackfillQueued    = "queued"
+	BackfillRunning   = "running"
+	BackfillDone      = "done"
+	BackfillFailed    = "failed"
+	BackfillCancelled = "cancelled"
+)
+
+// BackfillStatus reports the progress of a backfill for one appId-country
+type BackfillStatus struct {
+	ID         string    `json:"id"` // appId-country
+	AppID      string    `json:"appId"`
+	Country    string    `json:"country"`
+	State      string    `json:"state"`
+	Page       int       `json:"page"`  // last completed page
+	Pages      int       `json:"pages"` // pages offered by the source
+	Added      int       `json:"added"` // new reviews stored by this run
+	StartedAt  time.Time `json:"startedAt,omitzero"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// claim marks k as running, waiting for an in-flight poll to finish
+func (m *Manager) claim(ctx context.Context, k string) error {
+	for {
+		m.mu.Lock()
+		if !m.running[k] {
+			m.running[k] = true
+			m.mu.Unlock()
+			return nil
+		}
+		m.mu.Unlock()
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *Manager) release(k string) {
+	m.mu.Lock()
+	m.running[k] = false
+	m.mu.Unlock()
+}
+
+// Backfill walks every page the source offers for app (no early stop, no
+// maxPages cap) and stores the reviews not seen yet. The last completed page
+// is persisted after each page, so an interrupted run resumes from there.
+// progress (optional) is called after every page.
+func (m *Manager) Backfill(ctx context.Context, app AppConfig, progress func(BackfillStatus)) (BackfillStatus, error) {
+	k := storeKey(app.AppID, app.Country)
+	st := BackfillStatus{ID: k, AppID: app.AppID, Country: app.Country, State: BackfillRunning, StartedAt: time.Now().UTC()}
+	report := func() {
+		if progress != nil {
+			progress(st)
+		}
+	}
+	finish := func(state string, err error) (BackfillStatus, error) {
+		st.State = state
+		st.FinishedAt = time.Now().UTC()
+		if err != nil {
+			st.Error = err.Error()
+		}
+		report()
+		return st, err
+	}
+
+	src, err := m.sourceFor(app)
+	if err != nil {
+		return finish(BackfillFailed, err)
+	}
+	// never interleave with a poll of the same app (both append)
+	if err := m.claim(ctx, k); err != nil {
+		return finish(BackfillCancelled, err)
+	}
+	defer m.release(k)
+
+	st.Pages = src.Pages(app)
+	st.Page = m.store.BackfillProgress(app.AppID, app.Country)
+	if st.Page >= st.Pages {
+		st.Page = 0 // previous run completed the walk but didn't clear it
+	}
+	if st.Page > 0 {
+		log.Printf("[backfill %s] resuming after page %d/%d", k, st.Page, st.Pages)
+	}
+	report()
+
+	pp := m.cfg.pollFor(app)
+	seen := m.store.GetSeenSet(app.AppID, app.Country)
+	for page := st.Page + 1; page <= st.Pages; page++ {
+		pageCtx, cancel := context.WithTimeout(ctx, pp.PageTimeout)
+		// no validators: we want the full page even if unchanged
+		fp, err := FetchPageWithRetry(pageCtx, m.cfg, src, m.throttle, app, page, PageValidator{})
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return finish(BackfillCancelled, ctx.Err())
+			}
+			return finish(BackfillFailed, fmt.Errorf("page %d: %w", page, err))
+		}
+		if len(fp.Reviews) == 0 {
+			break // nothing older than this
+		}
+
+		toAppend := []Review{}
+		newIDs := []string{}
+		now := time.Now().UTC()
+		for _, r := range fp.Reviews {
+			if _, ok := seen[r.ID]; ok || m.cfg.expired(app, r, now) {
+				continue
+			}
+			seen[r.ID] = struct{}{}
+			newIDs = append(newIDs, r.ID)
+			toAppend = append(toAppend, r)
+		}
+		if len(toAppend) > 0 {
+			if err := m.store.AppendReviews(app.AppID, app.Country, toAppend, newIDs); err != nil {
+				return finish(BackfillFailed, fmt.Errorf("page %d: append: %w", page, err))
+			}
+		}
+		if err := m.store.SetBackfillProgress(app.AppID, app.Country, page); err != nil {
+			return finish(BackfillFailed, fmt.Errorf("page %d: saving progress: %w", page, err))
+		}
+		st.Page = page
+		st.Added += len(toAppend)
+		log.Printf("[backfill %s] page %d/%d: %d new (total %d)", k, page, st.Pages, len(toAppend), st.Added)
+		report()
+
+		if err := sleepCtx(ctx, pp.PageDelay); err != nil && ctx.Err() != nil {
+			return finish(BackfillCancelled, ctx.Err())
+		}
+	}
+
+	if err := m.store.SetBackfillProgress(app.AppID, app.Country, 0); err != nil {
+		log.Printf("[backfill %s] clearing progress: %v", k, err)
+	}
+	log.Printf("[backfill %s] done: %d new reviews", k, st.Added)
+	return finish(BackfillDone, nil)
+}
+
+// StartBackfill runs backfills in the background (one app at a time) and
+// returns their initial status; apps already being backfilled are skipped
+func (m *Manager) StartBackfill(apps []AppConfig) []BackfillStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queued := []AppConfig{}
+	out := []BackfillStatus{}
+	for _, app := range apps {
+		k := storeKey(app.AppID, app.Country)
+		if st, ok := m.backfills[k]; ok && (st.State == BackfillQueued || st.State == BackfillRunning) {
+			out = append(out, *st)
+			continue
+		}
+		st := &BackfillStatus{ID: k, AppID: app.AppID, Country: app.Country, State: BackfillQueued}
+		m.backfills[k] = st
+		out = append(out, *st)
+		queued = append(queued, app)
+	}
+	if len(queued) == 0 {
+		return out
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for _, app := range queued {
+			_, err := m.Backfill(m.ctx, app, func(st BackfillStatus) {
+				m.mu.Lock()
+				m.backfills[st.ID] = &st
+				m.mu.Unlock()
+			})
+			if err != nil {
+				log.Printf("[backfill %s-%s] %v", app.AppID, app.Country, err)
+			}
+		}
+	}()
+	return out
+}
+
+// BackfillStatuses returns the status of every backfill started since boot
+func (m *Manager) BackfillStatuses() []BackfillStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []BackfillStatus{}
+	for _, app := range m.cfg.Apps {
+		if st, ok := m.backfills[storeKey(app.AppID, app.Country)]; ok {
+			out = append(out, *st)
+		}
+	}
+	return out
+}
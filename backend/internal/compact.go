@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CompactResult reports what a compaction did to one appId-country
+type CompactResult struct {
+	ID          string `json:"id"`          // appId-country
+	Rows        int    `json:"rows"`        // rows before
+	Kept        int    `json:"kept"`        // rows after
+	Duplicates  int    `json:"duplicates"`  // same id+revision stored twice
+	Expired     int    `json:"expired"`     // rows of reviews older than the retention window
+	Quarantined int    `json:"quarantined"` // undecodable rows moved to the quarantine file
+	Compressed  int    `json:"compressed"`  // segments gzipped
+}
+
+func (r CompactResult) changed() bool {
+	return r.Duplicates > 0 || r.Expired > 0 || r.Quarantined > 0 || r.Compressed > 0
+}
+
+// Compacter is implemented by stores that can dedupe and expire old reviews
+type Compacter interface {
+	// Compact drops duplicate rows and, if retention > 0, every review whose
+	// latest revision is older than now-retention
+	Compact(appID, country string, retention time.Duration) (CompactResult, error)
+}
+
+var (
+	_ Compacter = (*FileStore)(nil)
+	_ Compacter = (*SQLStore)(nil)
+)
+
+// QuarantinePath: where compaction moves the corrupted rows of an app
+func (s *FileStore) QuarantinePath(appID, country string) string {
+	return s.quarantinePath(storeKey(appID, country))
+}
+
+func (s *FileStore) quarantinePath(key string) string {
+	return filepath.Join(s.baseDir, "reviews", "quarantine", key+".jsonl")
+}
+
+// Compact rewrites the segments of an app keeping one row per id+revision
+// (the last one, like reads do) and only the reviews within retention.
+// Corrupted rows are appended to the quarantine file, and segments whose
+// newest review is older than compressAfter are gzipped. Every rewritten
+// segment is written aside and renamed over the old one, like SaveState.
+func (s *FileStore) Compact(appID, country string, retention time.Duration) (CompactResult, error) {
+	res := CompactResult{ID: storeKey(appID, country)}
+	if s.readOnly {
+		return res, errReadOnly
+	}
+	changed, err := s.compactSegments(appID, country, retention, &res)
+	if err != nil || !changed {
+		return res, err
+	}
+	// ids may be gone: rebuild the indexes on next use
+	s.mu.Lock()
+	delete(s.index, res.ID)
+	s.mu.Unlock()
+	s.search.drop(appID, country)
+	return res, nil
+}
+
+func (s *FileStore) compactSegments(appID, country string, retention time.Duration, res *CompactResult) (bool, error) {
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+	m := s.manifests[res.ID]
+	if m == nil {
+		return false, nil
+	}
+	dir := s.ReviewsDir(appID, country)
+
+	type row struct {
+		r   Review
+		seg int
+	}
+	type rowKey struct {
+		id  string
+		rev int
+	}
+	rows := []row{}
+	at := map[rowKey]int{}           // position in rows
+	newest := map[string]time.Time{} // per id, for retention
+	corrupted := [][]byte{}
+	dirty := make([]bool, len(m.Segments))
+	for i, g := range m.Segments {
+		err := scanLines(filepath.Join(dir, g.Name), func(line []byte) {
+			res.Rows++
+			var r Review
+			if err := json.Unmarshal(line, &r); err != nil || r.ID == "" {
+				corrupted = append(corrupted, append([]byte(nil), line...))
+				dirty[i] = true
+				return
+			}
+			k := rowKey{r.ID, r.Revision}
+			if j, ok := at[k]; ok {
+				// last row wins, in the place of the first one
+				rows[j].r = r
+				dirty[rows[j].seg] = true
+				dirty[i] = true
+				res.Duplicates++
+			} else {
+				at[k] = len(rows)
+				rows = append(rows, row{r, i})
+			}
+			if r.SubmittedAt.After(newest[r.ID]) {
+				newest[r.ID] = r.SubmittedAt
+			}
+		})
+		if err != nil {
+			return false, err // e.g. a line over 1MB: leave the files alone
+		}
+	}
+
+	now := time.Now().UTC()
+	kept := make([][]Review, len(m.Segments))
+	for _, x := range rows {
+		if retention > 0 && newest[x.r.ID].Before(now.Add(-retention)) {
+			res.Expired++
+			dirty[x.seg] = true
+			continue
+		}
+		kept[x.seg] = append(kept[x.seg], x.r)
+	}
+	compress := make([]bool, len(m.Segments))
+	for i, g := range m.Segments {
+		// never the current month: it is still being appended to
+		if s.compressAfter > 0 && !g.compressed() && g.Max.Before(now.Add(-s.compressAfter)) && g.month() != segmentMonth(now) {
+			compress[i] = true
+		}
+	}
+	res.Quarantined = len(corrupted)
+	res.Kept = res.Rows - res.Duplicates - res.Expired - res.Quarantined
+
+	if len(corrupted) > 0 {
+		if err := appendLines(s.QuarantinePath(appID, country), corrupted); err != nil {
+			return false, fmt.Errorf("quarantine: %w", err)
+		}
+	}
+	changed := false
+	segs := []segment{}
+	stale := []string{}
+	for i, g := range m.Segments {
+		if !dirty[i] && !compress[i] {
+			segs = append(segs, g)
+			continue
+		}
+		changed = true
+		old := filepath.Join(dir, g.Name)
+		if len(kept[i]) == 0 {
+			if err := os.Remove(old); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			continue
+		}
+		ng := segment{Name: g.Name}
+		if compress[i] {
+			ng.Name = g.month() + ".jsonl.gz"
+			res.Compressed++
+		}
+		for _, r := range kept[i] {
+			ng.widen(r.SubmittedAt)
+			ng.Rows++
+		}
+		size, err := writeSegment(filepath.Join(dir, ng.Name), ng.compressed(), kept[i])
+		if err != nil {
+			return false, err
+		}
+		ng.Size = size
+		if ng.Name != g.Name {
+			// the manifest names the old file until it is saved below
+			stale = append(stale, old)
+		}
+		segs = append(segs, ng)
+	}
+	if !changed {
+		return false, nil
+	}
+	m.Segments = segs
+	if err := writeManifest(dir, m); err != nil {
+		return false, err
+	}
+	for _, p := range stale {
+		os.Remove(p)
+	}
+	return true, nil
+}
+
+func appendLines(path string, lines [][]byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		w.Write(l)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Compact on the SQL store: drop all but the last row of each id+revision,
+// then the reviews outside retention (there are no corrupted rows here)
+func (s *SQLStore) Compact(appID, country string, retention time.Duration) (CompactResult, error) {
+	res := CompactResult{ID: storeKey(appID, country)}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM reviews WHERE app_id = ? AND country = ?`, appID, country).Scan(&res.Rows); err != nil {
+		return res, err
+	}
+	dup, err := tx.Exec(`DELETE FROM reviews WHERE app_id = ? AND country = ? AND seq NOT IN (
+			SELECT MAX(seq) FROM reviews WHERE app_id = ? AND country = ? GROUP BY id, revision
+		)`, appID, country, appID, country)
+	if err != nil {
+		return res, err
+	}
+	n, _ := dup.RowsAffected()
+	res.Duplicates = int(n)
+
+	if retention > 0 {
+		cutoff := time.Now().UTC().Add(-retention).UnixMilli()
+		// latest.submitted_at is the newest revision's date
+		exp, err := tx.Exec(`DELETE FROM reviews WHERE app_id = ? AND country = ? AND id IN (
+				SELECT id FROM latest WHERE app_id = ? AND country = ? AND submitted_at < ?
+			)`, appID, country, appID, country, cutoff)
+		if err != nil {
+			return res, err
+		}
+		n, _ := exp.RowsAffected()
+		res.Expired = int(n)
+		if _, err := tx.Exec(`DELETE FROM seen WHERE app_id = ? AND country = ? AND id IN (
+				SELECT id FROM latest WHERE app_id = ? AND country = ? AND submitted_at < ?
+			)`, appID, country, appID, country, cutoff); err != nil {
+			return res, err
+		}
+		if _, err := tx.Exec(`DELETE FROM latest WHERE app_id = ? AND country = ? AND submitted_at < ?`,
+			appID, country, cutoff); err != nil {
+			return res, err
+		}
+	}
+	res.Kept = res.Rows - res.Duplicates - res.Expired
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+	if res.changed() {
+		s.search.drop(appID, country)
+	}
+	return res, nil
+}
+
+// Compact compacts one app with its retention policy, never alongside a
+// poll or backfill of the same app
+func (m *Manager) Compact(ctx context.Context, app AppConfig) (CompactResult, error) {
+	c, ok := m.store.(Compacter)
+	if !ok {
+		return CompactResult{}, errors.New("store does not support compaction")
+	}
+	k := storeKey(app.AppID, app.Country)
+	if err := m.claim(ctx, k); err != nil {
+		return CompactResult{ID: k}, err
+	}
+	defer m.release(k)
+	return c.Compact(app.AppID, app.Country, m.cfg.retentionFor(app))
+}
+
+// compactor runs Compact on every app each compactIntervalHours
+func (m *Manager) compactor(ctx context.Context, interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, app := range m.cfg.Apps {
+				res, err := m.Compact(ctx, app)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("[compact %s-%s] %v", app.AppID, app.Country, err)
+					continue
+				}
+				if res.changed() {
+					log.Printf("[compact %s] %d -> %d rows (%d duplicates, %d expired, %d quarantined, %d segments gzipped)", res.ID, res.Rows, res.Kept, res.Duplicates, res.Expired, res.Quarantined, res.Compressed)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The server and the CLIs writing to data/ (backfill, import, compact, ...)
+// each keep their own manifests and seen sets in memory, so two of them on
+// the same directory would duplicate rows and overwrite each other's
+// manifest.json/state.json. They all take an exclusive lock on data/.lock
+// first; the OS drops it when the process exits, crash included.
+
+// ErrDataLocked: another process holds the lock of the data directory
+var ErrDataLocked = errors.New("data directory in use by another process")
+
+// DataLock is a held lock on a data directory
+type DataLock struct {
+	f *os.File
+}
+
+// LockDataDir takes the lock of dir without waiting: ErrDataLocked (with
+// the pid of the holder, when known) if the server or a CLI already has it
+func LockDataDir(dir string) (*DataLock, error) {
+	path := filepath.Join(dir, ".lock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrDataLocked) {
+			if b, _ := os.ReadFile(path); len(b) > 0 {
+				return nil, fmt.Errorf("%w (pid %s)", ErrDataLocked, b)
+			}
+		}
+		return nil, err
+	}
+	// the holder's pid, for the message above
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	return &DataLock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *DataLock) Unlock() error {
+	l.f.Truncate(0)
+	return l.f.Close() // closing the file drops the lock
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package internal
+
+import "os"
+
+// no flock here: the lock is not enforced, keep to one writer by hand
+func lockFile(f *os.File) error { return nil }
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write ordering of the JSONL store, so that a crash at any point leaves
+// something recoverSegments can repair:
+//  1. rows are appended to the segment and fsynced;
+//  2. the manifest (min/max/rows/size per segment) is saved atomically;
+//  3. state.json (lastPoll) is saved atomically.
+// Seen ids are derived from the segments, so they can't disagree with them.
+
+// writeFileAtomic: tmp + fsync + rename + fsync of the directory, so after a
+// crash path holds either the old or the new content
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes renames/creations in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// recoverSegments reconciles a manifest with the files in dir, after an
+// unclean shutdown:
+//   - a torn last line (crash mid-append) is cut off and quarantined;
+//   - a segment whose size differs from the manifest (rows appended, manifest
+//     not saved yet) is rescanned for its min/max/rows;
+//   - segment files the manifest doesn't know are adopted, leftovers of an
+//     interrupted compaction (*.tmp, the stale twin of a gzipped segment)
+//     removed, and entries whose file is gone dropped.
+//
+// A read-only store only fixes its copy of the manifest: nothing is cut,
+// removed or saved.
+func (s *FileStore) recoverSegments(dir string, m *manifest) error {
+	key := filepath.Base(dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	changed := false
+	byName := map[string]bool{}
+	byMonth := map[string]bool{}
+	for _, g := range m.Segments {
+		byName[g.Name] = true
+		byMonth[g.month()] = true
+	}
+	onDisk := map[string]bool{}
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(dir, name)
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			if !s.readOnly {
+				os.Remove(path)
+			}
+		case !strings.HasSuffix(name, ".jsonl") && !strings.HasSuffix(name, ".jsonl.gz"):
+		case byName[name]:
+			onDisk[name] = true
+		case byMonth[segment{Name: name}.month()]:
+			// the manifest names the other variant of this month
+			if !s.readOnly {
+				log.Printf("[store] %s: removing leftover segment %s", key, name)
+				os.Remove(path)
+			}
+		default:
+			log.Printf("[store] %s: adopting segment %s missing from the manifest", key, name)
+			m.Segments = append(m.Segments, segment{Name: name, Size: -1})
+			onDisk[name] = true
+			changed = true
+		}
+	}
+
+	segs := m.Segments[:0]
+	for _, g := range m.Segments {
+		if !onDisk[g.Name] {
+			log.Printf("[store] %s: segment %s is gone, dropping it from the manifest", key, g.Name)
+			changed = true
+			continue
+		}
+		path := filepath.Join(dir, g.Name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if fi.Size() == g.Size {
+			segs = append(segs, g)
+			continue
+		}
+		if s.readOnly {
+			// possibly being appended to by the writer: take what is there
+			if ng, err := rescanSegment(path, g.Name); err == nil {
+				g = ng
+			}
+			segs = append(segs, g)
+			continue
+		}
+		ng, err := s.repairSegment(key, path, g)
+		if err != nil {
+			return err
+		}
+		log.Printf("[store] %s: segment %s rescanned after an unclean shutdown (%d rows)", key, g.Name, ng.Rows)
+		segs = append(segs, ng)
+		changed = true
+	}
+	m.Segments = segs
+	m.sort()
+	if !changed || s.readOnly {
+		return nil
+	}
+	return writeManifest(dir, m)
+}
+
+// repairSegment cuts a torn tail off a segment and recomputes its manifest entry
+func (s *FileStore) repairSegment(key, path string, g segment) (segment, error) {
+	if !g.compressed() {
+		torn, err := cutTornLine(path)
+		if err != nil {
+			return g, err
+		}
+		if len(torn) > 0 {
+			log.Printf("[store] %s: %s ended with a torn line (%d bytes), moved to quarantine", key, g.Name, len(torn))
+			if err := appendLines(s.quarantinePath(key), [][]byte{torn}); err != nil {
+				return g, err
+			}
+		}
+	}
+	ng, err := rescanSegment(path, g.Name)
+	if err != nil && g.compressed() && g.Size > 0 {
+		// a gzip member cut short: go back to the last size the manifest vouched for
+		log.Printf("[store] %s: %s: %v, truncating to %d bytes", key, g.Name, err, g.Size)
+		if err := os.Truncate(path, g.Size); err != nil {
+			return g, err
+		}
+		ng, err = rescanSegment(path, g.Name)
+	}
+	return ng, err
+}
+
+// cutTornLine truncates path after its last newline and returns what was cut
+func cutTornLine(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndexByte(b, '\n')
+	if i == len(b)-1 {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(i + 1)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return b[i+1:], f.Close()
+}
+
+// rescanSegment rebuilds the manifest entry of a segment from its rows
+func rescanSegment(path, name string) (segment, error) {
+	g := segment{Name: name}
+	err := scanLines(path, func(line []byte) {
+		var r Review
+		if json.Unmarshal(line, &r) != nil {
+			return // left for compaction to quarantine
+		}
+		g.widen(r.SubmittedAt)
+		g.Rows++
+	})
+	if err != nil {
+		return g, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return g, nil
+		}
+		return g, err
+	}
+	g.Size = fi.Size()
+	return g, nil
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeFeedOptions tune the offline App Store stand-in (cmd/fakefeed)
+type FakeFeedOptions struct {
+	PageSize     int           // entries per page, Apple uses 50
+	MaxPages     int           // pages past this are 400, like Apple
+	EmptyRate    float64       // fraction of pages served with no entries
+	CorruptRate  float64       // fraction of JSON pages served truncated
+	ErrorRate    float64       // fraction of requests answered with a 5xx
+	TimeoutRate  float64       // fraction of requests that hang for Hang
+	ThrottleRate float64       // fraction of requests answered 429 + Retry-After
+	RetryAfter   time.Duration // Retry-After sent with injected 429s
+	Hang         time.Duration // how long a "timeout" request stalls
+	Seed         int64         // same seed + same request order => same answers
+}
+
+// FakeFeed serves appleFeedRoot-shaped pages built from the local store
+type FakeFeed struct {
+	store ReviewStore
+	opts  FakeFeedOptions
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewFakeFeed(st ReviewStore, opts FakeFeedOptions) *FakeFeed {
+	if opts.PageSize <= 0 {
+		opts.PageSize = 50
+	}
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = appleMaxPages
+	}
+	if opts.Hang <= 0 {
+		opts.Hang = 30 * time.Second
+	}
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = 5 * time.Second
+	}
+	return &FakeFeed{store: st, opts: opts, rnd: rand.New(rand.NewSource(opts.Seed))}
+}
+
+// roll draws the fault injection dice for one request (deterministic per seed)
+func (f *FakeFeed) roll() (fail, hang, empty, corrupt, throttle bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	throttle = f.rnd.Float64() < f.opts.ThrottleRate
+	fail = f.rnd.Float64() < f.opts.ErrorRate
+	hang = f.rnd.Float64() < f.opts.TimeoutRate
+	empty = f.rnd.Float64() < f.opts.EmptyRate
+	corrupt = f.rnd.Float64() < f.opts.CorruptRate
+	return
+}
+
+// parseFeedPath understands the RSS path built by feedURL:
+// /{country}/rss/customerreviews/id={appId}/sortBy=mostRecent/page={n}/{json|xml}
+func parseFeedPath(p string) (country, appID string, page int, format string, ok bool) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) != 7 || parts[1] != "rss" || parts[2] != "customerreviews" {
+		return "", "", 0, "", false
+	}
+	format = parts[6]
+	if format != FormatJSON && format != FormatXML {
+		return "", "", 0, "", false
+	}
+	appID, ok1 := strings.CutPrefix(parts[3], "id=")
+	pageStr, ok2 := strings.CutPrefix(parts[5], "page=")
+	n, err := strconv.Atoi(pageStr)
+	if !ok1 || !ok2 || err != nil || appID == "" {
+		return "", "", 0, "", false
+	}
+	return parts[0], appID, n, format, true
+}
+
+func (f *FakeFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	country, appID, page, format, ok := parseFeedPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if page < 1 || page > f.opts.MaxPages {
+		http.Error(w, "CustomerReviews RSS page depth is limited to 10", http.StatusBadRequest)
+		return
+	}
+
+	fail, hang, empty, corrupt, throttle := f.roll()
+	if throttle {
+		w.Header().Set("Retry-After", strconv.Itoa(int(f.opts.RetryAfter.Seconds())))
+		http.Error(w, "fakefeed: injected throttling", http.StatusTooManyRequests)
+		return
+	}
+	if hang {
+		select {
+		case <-time.After(f.opts.Hang):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fail {
+		http.Error(w, "fakefeed: injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	// the whole history, newest first (same order as sortBy=mostRecent)
+	all, err := f.store.ReadRecent(appID, country, allTime)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pageRevs := []Review{}
+	start := (page - 1) * f.opts.PageSize
+	if !empty && start < len(all) {
+		pageRevs = all[start:min(start+f.opts.PageSize, len(all))]
+	}
+
+	var b []byte
+	contentType := "application/json"
+	if format == FormatXML {
+		feed := atomFeed{Entry: []atomEntry{}}
+		if page == 1 && len(pageRevs) > 0 {
+			// Apple puts the app itself first on page 1 (no rating)
+			feed.Entry = append(feed.Entry, atomEntry{ID: appID})
+		}
+		for _, rv := range pageRevs {
+			feed.Entry = append(feed.Entry, toAtomEntry(rv))
+		}
+		x, _ := xml.Marshal(feed)
+		b = append([]byte(xml.Header), x...)
+		contentType = "application/atom+xml"
+	} else {
+		var root appleFeedRoot
+		root.Feed.Entry = []appleEntry{}
+		if page == 1 && len(pageRevs) > 0 {
+			root.Feed.Entry = append(root.Feed.Entry, appleEntry{ID: labeled{Label: appID}})
+		}
+		for _, rv := range pageRevs {
+			root.Feed.Entry = append(root.Feed.Entry, toAppleEntry(rv))
+		}
+		b, _ = json.Marshal(root)
+		if corrupt {
+			b = b[:len(b)/2] // truncated payload, like the real endpoint sometimes does
+		}
+	}
+
+	// cache validators: ETag from the body, Last-Modified from the newest entry
+	sum := sha256.Sum256(b)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if len(pageRevs) > 0 {
+		w.Header().Set("Last-Modified", pageRevs[0].SubmittedAt.Format(http.TimeFormat))
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && inm == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(b)
+}
+
+func toAppleEntry(r Review) appleEntry {
+	var e appleEntry
+	e.ID.Label = r.ID
+	e.Updated.Label = r.SubmittedAt.Format(time.RFC3339)
+	e.Author.Name.Label = r.Author
+	e.Author.URI.Label = r.AuthorURI
+	e.Rating.Label = strconv.Itoa(r.Rating)
+	e.Version.Label = r.AppVersion
+	e.Title.Label = r.Title
+	e.Content.Label = r.Content
+	e.Content.Attributes.Type = r.ContentType
+	e.VoteSum.Label = strconv.Itoa(r.VoteSum)
+	e.VoteCount.Label = strconv.Itoa(r.VoteCount)
+	if r.Link != "" {
+		e.Link.Attributes.Rel = "related"
+		e.Link.Attributes.Href = r.Link
+	}
+	return e
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -27,63 +28,192 @@ type appleEntry struct {
 	Updated labeled `json:"updated"`
 	Author  struct {
 		Name labeled `json:"name"`
+		URI  labeled `json:"uri"`
 	} `json:"author"`
 	Rating  labeled `json:"im:rating"`
-	Content labeled `json:"content"`
-	Title   labeled `json:"title"`
+	Version labeled `json:"im:version"`
+	Content struct {
+		Label      string `json:"label"`
+		Attributes struct {
+			Type string `json:"type"` // "text" / "html"
+		} `json:"attributes"`
+	} `json:"content"`
+	Title     labeled `json:"title"`
+	VoteSum   labeled `json:"im:voteSum"`
+	VoteCount labeled `json:"im:voteCount"`
+	Link      struct {
+		Attributes struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		} `json:"attributes"`
+	} `json:"link"`
 }
 
 // ---- types - errors ----
 
 type HTTPError struct {
-	Status int
-	Body   string
-	URL    string
+	Status     int
+	Body       string
+	URL        string
+	RetryAfter time.Duration // from the Retry-After header (429/503), 0 if absent
 }
 
 func (e *HTTPError) Error() string {
 	return fmt.Sprintf("http %d %s: %s", e.Status, e.URL, e.Body)
 }
 
-// ---- client with timeout ----
+// ---- client ----
+
+// httpClient has no timeout of its own: requests are bounded by the page
+// deadline (poll.pageTimeoutSeconds) on their context
+var httpClient = &http.Client{}
+
+// Feed formats served by the customer reviews RSS
+const (
+	FormatJSON = "json"
+	FormatXML  = "xml"  // Atom
+	FormatAuto = "auto" // JSON, falling back to XML if the payload can't be decoded
+)
+
+// maxFeedBody caps how much of a feed page we read (a page is ~100KB)
+const maxFeedBody = 8 << 20
+
+func feedURL(baseURL, country, appID string, page int, format string) string {
+	return fmt.Sprintf("%s/%s/rss/customerreviews/id=%s/sortBy=mostRecent/page=%d/%s", baseURL, country, appID, page, format)
+}
+
+// FeedPage is one page of reviews as returned by a ReviewSource
+type FeedPage struct {
+	Reviews     []Review
+	Format      string        // format actually decoded (json / xml)
+	NotModified bool          // 304: page unchanged since cond, Reviews is empty
+	Validator   PageValidator // to send on the next fetch of this page
+}
+
+// DecodeError: the feed answered 200 but the payload is malformed/truncated
+type DecodeError struct {
+	Format string
+	URL    string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s %s: %v", e.Format, e.URL, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// ---- Apple RSS source ----
+
+// appleMaxPages: the customer reviews RSS never serves more than 10 pages
+const appleMaxPages = 10
 
-var httpClient = &http.Client{
-	Timeout: 10 * time.Second,
+type appleRSSSource struct {
+	baseURL string
 }
 
-func feedURL(country, appID string, page int) string {
-	return fmt.Sprintf("https://itunes.apple.com/%s/rss/customerreviews/id=%s/sortBy=mostRecent/page=%d/json", country, appID, page)
+func init() {
+	RegisterSource(DefaultSource, func(cfg *Config) ReviewSource {
+		return appleRSSSource{baseURL: cfg.FeedBaseURL}
+	})
+}
+
+func (appleRSSSource) Name() string { return DefaultSource }
+
+func (appleRSSSource) Pages(app AppConfig) int { return appleMaxPages }
+
+func (s appleRSSSource) FetchPage(ctx context.Context, app AppConfig, page int, cond PageValidator) (FeedPage, error) {
+	format := app.FeedFormat()
+	if format != FormatAuto {
+		return fetchPageOnce(ctx, s.baseURL, app, page, format, cond)
+	}
+	// after a fallback the stored validator belongs to the XML URL
+	format = FormatJSON
+	if cond.Format == FormatXML {
+		format = FormatXML
+	}
+	fp, err := fetchPageOnce(ctx, s.baseURL, app, page, format, cond)
+	var derr *DecodeError
+	if format == FormatJSON && errors.As(err, &derr) {
+		// the JSON endpoint sometimes sends broken payloads: try Atom,
+		// a second request that takes its own token
+		if werr := WaitThrottle(ctx); werr != nil {
+			if errors.Is(werr, errThrottled) {
+				return fp, err
+			}
+			return FeedPage{}, werr
+		}
+		return fetchPageOnce(ctx, s.baseURL, app, page, FormatXML, cond)
+	}
+	return fp, err
 }
 
-// Fetch a feed page (single attempt)
-func fetchPageOnce(ctx context.Context, country, appID string, page int) ([]Review, error) {
-	url := feedURL(country, appID, page)
+// Fetch a feed page in the given format (single attempt).
+// Validators in cond are sent only if they were issued for the same format.
+func fetchPageOnce(ctx context.Context, baseURL string, app AppConfig, page int, format string, cond PageValidator) (FeedPage, error) {
+	url := feedURL(baseURL, app.Country, app.AppID, page, format)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		return FeedPage{}, err
 	}
 	req.Header.Set("User-Agent", "recent-reviews-backend/1.1")
+	if cond.Format == format {
+		if cond.ETag != "" {
+			req.Header.Set("If-None-Match", cond.ETag)
+		}
+		if cond.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cond.LastModified)
+		}
+	}
 	req = req.WithContext(ctx)
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, err // could be net.Error (timeout/temporary)
+		return FeedPage{}, err // could be net.Error (timeout/temporary)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return FeedPage{Format: format, NotModified: true, Validator: cond}, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
-		return nil, &HTTPError{Status: resp.StatusCode, Body: string(body), URL: url}
+		return FeedPage{}, &HTTPError{
+			Status:     resp.StatusCode,
+			Body:       string(body),
+			URL:        url,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
-	var root appleFeedRoot
-	if err := json.NewDecoder(resp.Body).Decode(&root); err != nil {
-		return nil, err
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBody))
+	if err != nil {
+		return FeedPage{}, err
 	}
 
+	var entries []appleEntry
+	if format == FormatXML {
+		entries, err = decodeAtomFeed(body)
+	} else {
+		var root appleFeedRoot
+		err = json.Unmarshal(body, &root)
+		entries = root.Feed.Entry
+	}
+	if err != nil {
+		return FeedPage{}, &DecodeError{Format: format, URL: url, Err: err}
+	}
+	v := PageValidator{
+		Format:       format,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return FeedPage{Reviews: entriesToReviews(entries, app), Format: format, Validator: v}, nil
+}
+
+func entriesToReviews(entries []appleEntry, app AppConfig) []Review {
 	now := time.Now().UTC()
-	reviews := make([]Review, 0, len(root.Feed.Entry))
-	for _, e := range root.Feed.Entry {
+	reviews := make([]Review, 0, len(entries))
+	for _, e := range entries {
 		if e.Rating.Label == "" { // App's entry - skip it!
 			continue
 		}
@@ -97,17 +227,23 @@ func fetchPageOnce(ctx context.Context, country, appID string, page int) ([]Revi
 		}
 		r := Review{
 			ID:          e.ID.Label,
-			AppID:       appID,
-			Country:     country,
+			AppID:       app.AppID,
+			Country:     app.Country,
 			Author:      e.Author.Name.Label,
+			AuthorURI:   e.Author.URI.Label,
 			Rating:      atoiSafe(e.Rating.Label),
 			Title:       e.Title.Label,
 			Content:     e.Content.Label,
+			ContentType: e.Content.Attributes.Type,
+			AppVersion:  e.Version.Label,
+			VoteSum:     atoiSafe(e.VoteSum.Label),
+			VoteCount:   atoiSafe(e.VoteCount.Label),
+			Link:        e.Link.Attributes.Href,
 			SubmittedAt: t.UTC(),
 		}
 		reviews = append(reviews, r)
 	}
-	return reviews, nil
+	return reviews
 }
 
 func atoiSafe(s string) int {
@@ -130,9 +266,14 @@ func isTimeout(err error) bool {
 }
 
 func errorType(err error) string {
+	if isRateLimited(err) {
+		return "rate_limited"
+	}
 	switch e := err.(type) {
 	case *HTTPError:
 		return fmt.Sprintf("http_status_%d", e.Status)
+	case *DecodeError:
+		return "decode_error"
 	default:
 		if isTimeout(err) {
 			return "network_timeout"
@@ -141,36 +282,72 @@ func errorType(err error) string {
 	}
 }
 
-// Fetch with retry: 3 attempts (1 + 2 retry) with increasing backoff
-func FetchPageWithRetry(ctx context.Context, cfg *Config, country, appID string, page int) ([]Review, error) {
-	const attempts = 3
-	base := 500 * time.Millisecond
+// Fetch with retry: Retry.Attempts attempts (default 1 + 2 retry) with increasing
+// backoff. Every attempt waits for the manager-wide throttle (and so does any extra
+// request of the source, see WaitThrottle); a 429/503 extends it by Retry-After (or
+// the backoff if the header is missing).
+func FetchPageWithRetry(ctx context.Context, cfg *Config, src ReviewSource, th *Throttle, app AppConfig, page int, cond PageValidator) (FeedPage, error) {
+	rp := cfg.retryFor(app)
+	attempts := rp.Attempts
 
 	var lastErr error
+	sent := false // did any attempt reach the feed?
 	for i := 0; i < attempts; i++ {
-		revs, err := fetchPageOnce(ctx, country, appID, page)
+		if err := th.Wait(ctx); err != nil {
+			if !errors.Is(err, errThrottled) {
+				return FeedPage{}, err // cancelled
+			}
+			// hold-off longer than our deadline: give up as rate limited
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+		sent = true
+		fp, err := src.FetchPage(withThrottle(ctx, th), app, page, cond)
 		if err == nil {
-			return revs, nil
+			return fp, nil
 		}
 		lastErr = err
 
+		// backoff with simple jitter
+		sleep := time.Duration(1<<i) * rp.BaseDelay // 0:500ms, 1:1s, 2:2s
+		jitter := time.Duration(0)
+		if rp.MaxJitter > 0 {
+			jitter = time.Duration(time.Now().UnixNano() % int64(rp.MaxJitter)) // 0-200ms
+		}
+
+		if isRateLimited(err) {
+			// hold off every worker, not just this page
+			delay := sleep + jitter
+			var he *HTTPError
+			if errors.As(err, &he) && he.RetryAfter > 0 {
+				delay = he.RetryAfter
+			}
+			th.Defer(delay)
+			if i < attempts-1 {
+				continue // the next attempt waits on the throttle
+			}
+		}
+
 		// last attempt? exit!
 		if i == attempts-1 {
 			break
 		}
 
-		// backoff with simple jitter
-		sleep := time.Duration(1<<i) * base                    // 0:500ms, 1:1s, 2:2s
-		jitter := time.Duration(time.Now().UnixNano() % 200e6) // 0-200ms
 		select {
 		case <-time.After(sleep + jitter):
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return FeedPage{}, ctx.Err()
 		}
 	}
 
+	if !sent {
+		// held off by someone else's 429: nothing failed on our side
+		return FeedPage{}, lastErr
+	}
 	// all attempts failed: notify webhook (best-effort)
-	id := appID + "-" + country
+	id := app.AppID + "-" + app.Country
 	_ = NotifyWebhook(cfg.WebhookURL, id, errorType(lastErr))
-	return nil, lastErr
+	return FeedPage{}, lastErr
 }
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingSource struct{ calls atomic.Int32 }
+
+func (*countingSource) Name() string            { return "counting" }
+func (*countingSource) Pages(app AppConfig) int { return 1 }
+func (s *countingSource) FetchPage(ctx context.Context, app AppConfig, page int, cond PageValidator) (FeedPage, error) {
+	s.calls.Add(1)
+	return FeedPage{}, &HTTPError{Status: http.StatusTooManyRequests}
+}
+
+// a poll held off by the shared throttle sends nothing, so it must not
+// report a failure
+func TestFetchPageWithRetryHeldOffSendsNoWebhook(t *testing.T) {
+	var posts atomic.Int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer hook.Close()
+
+	cfg := &Config{WebhookURL: hook.URL}
+	th := NewThrottle(0, 0)
+	th.Defer(time.Hour)
+	src := &countingSource{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := FetchPageWithRetry(ctx, cfg, src, th, AppConfig{AppID: "1", Country: "us"}, 1, PageValidator{})
+	if !errors.Is(err, errThrottled) {
+		t.Fatalf("err = %v, want errThrottled", err)
+	}
+	if n := src.calls.Load(); n != 0 {
+		t.Fatalf("source called %d times during the hold-off", n)
+	}
+	if n := posts.Load(); n != 0 {
+		t.Fatalf("%d webhooks posted during the hold-off", n)
+	}
+}
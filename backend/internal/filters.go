@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort orders of GET /reviews
+const (
+	SortNewest = "newest"
+	SortOldest = "oldest"
+	SortRating = "rating" // lowest rating first (newest first within a rating)
+)
+
+// maxHours: the ?hours= clamp; from/to can reach further back
+const maxHours = 24 * 90
+
+// reviewFilter: the query of GET /reviews, validated
+type reviewFilter struct {
+	From, To  time.Time
+	MinRating int      // 0 = no bound
+	MaxRating int      // 0 = no bound
+	Terms     []string // q, lowercased: every term must be in title or content
+	Author    string   // lowercased substring of the author
+	Removed   string   // "false" (hide removed, default), "true" (only removed), "all"
+	Sort      string
+}
+
+// parseReviewFilter reads the filters of GET /reviews; any bad value is an
+// error (the handler answers 400), nothing falls back to a default silently
+func parseReviewFilter(q url.Values, now time.Time) (reviewFilter, error) {
+	f := reviewFilter{To: now, Sort: SortNewest, Removed: "false"}
+
+	hours := 48
+	if hs := q.Get("hours"); hs != "" {
+		n, err := strconv.Atoi(hs)
+		if err != nil || n < 1 || n > maxHours {
+			return f, fmt.Errorf("hours must be 1..%d", maxHours)
+		}
+		hours = n
+	}
+	if q.Get("hours") != "" && q.Get("from") != "" {
+		return f, fmt.Errorf("use either hours or from/to")
+	}
+	var err error
+	if f.From, f.To, err = parseRange(q, now, time.Duration(hours)*time.Hour); err != nil {
+		return f, err
+	}
+
+	if f.MinRating, err = parseRating(q, "minRating"); err != nil {
+		return f, err
+	}
+	if f.MaxRating, err = parseRating(q, "maxRating"); err != nil {
+		return f, err
+	}
+	if f.MinRating > 0 && f.MaxRating > 0 && f.MinRating > f.MaxRating {
+		return f, fmt.Errorf("minRating must not be above maxRating")
+	}
+
+	f.Terms = strings.Fields(strings.ToLower(q.Get("q")))
+	f.Author = strings.ToLower(strings.TrimSpace(q.Get("author")))
+
+	// removed reviews are hidden unless asked for, like in /stats and /search
+	if rs := q.Get("removed"); rs != "" {
+		if rs != "true" && rs != "false" && rs != "all" {
+			return f, fmt.Errorf("removed must be true, false or all")
+		}
+		f.Removed = rs
+	}
+	if s := q.Get("sort"); s != "" {
+		if s != SortNewest && s != SortOldest && s != SortRating {
+			return f, fmt.Errorf("sort must be %s, %s or %s", SortNewest, SortOldest, SortRating)
+		}
+		f.Sort = s
+	}
+	return f, nil
+}
+
+// parseRange reads from/to (RFC3339); to defaults to now, from to to-span
+func parseRange(q url.Values, now time.Time, span time.Duration) (from, to time.Time, err error) {
+	to = now
+	if ts := q.Get("to"); ts != "" {
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return from, to, fmt.Errorf("to %q: not an RFC3339 date", ts)
+		}
+		to = t.UTC()
+	}
+	from = to.Add(-span)
+	if fs := q.Get("from"); fs != "" {
+		t, err := time.Parse(time.RFC3339, fs)
+		if err != nil {
+			return from, to, fmt.Errorf("from %q: not an RFC3339 date", fs)
+		}
+		from = t.UTC()
+	}
+	if from.After(to) {
+		return from, to, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
+
+func parseRating(q url.Values, name string) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 5 {
+		return 0, fmt.Errorf("%s must be 1..5", name)
+	}
+	return n, nil
+}
+
+// match: r passes every filter but the window (the store applies that one)
+func (f reviewFilter) match(r Review) bool {
+	if f.MinRating > 0 && r.Rating < f.MinRating {
+		return false
+	}
+	if f.MaxRating > 0 && r.Rating > f.MaxRating {
+		return false
+	}
+	if f.Removed != "all" && r.Removed() != (f.Removed == "true") {
+		return false
+	}
+	if f.Author != "" && !strings.Contains(strings.ToLower(r.Author), f.Author) {
+		return false
+	}
+	if len(f.Terms) > 0 {
+		text := strings.ToLower(r.Title + "\n" + r.Content)
+		for _, t := range f.Terms {
+			if !strings.Contains(text, t) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f reviewFilter) apply(revs []Review) []Review {
+	out := make([]Review, 0, len(revs))
+	for _, r := range revs {
+		if f.match(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,351 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Import formats
+const (
+	ImportCSV   = "csv"
+	ImportJSONL = "jsonl"
+)
+
+// importBatch: rows per AppendReviews call
+const importBatch = 500
+
+// maxImportErrors: rejected rows reported one by one (the count covers all)
+const maxImportErrors = 50
+
+// importFields: the Review fields an export can fill (JSON names)
+var importFields = []string{
+	"id", "author", "authorUri", "rating", "title", "content", "contentType",
+	"appVersion", "voteSum", "voteCount", "link", "submittedAt", "appId", "country",
+}
+
+// ImportOptions: where the rows go and how to read them
+type ImportOptions struct {
+	AppID   string
+	Country string
+	Format  string // csv (header row required) or jsonl
+	// Mapping: Review field -> CSV column / JSON key in the export;
+	// unmapped fields are looked up under their own name
+	Mapping map[string]string
+}
+
+// ParseImportMapping reads "id=review_id,rating=stars" into a mapping
+func ParseImportMapping(s string) (map[string]string, error) {
+	m := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, col, ok := strings.Cut(pair, "=")
+		field, col = strings.TrimSpace(field), strings.TrimSpace(col)
+		if !ok || field == "" || col == "" {
+			return nil, fmt.Errorf("mapping %q: expected field=column", pair)
+		}
+		if !slices.Contains(importFields, field) {
+			return nil, fmt.Errorf("mapping: unknown field %q (known: %s)", field, strings.Join(importFields, ", "))
+		}
+		m[field] = col
+	}
+	return m, nil
+}
+
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResult: every row is accepted, a duplicate or rejected
+type ImportResult struct {
+	ID         string        `json:"id"` // appId-country
+	Rows       int           `json:"rows"`
+	Accepted   int           `json:"accepted"`
+	Duplicates int           `json:"duplicates"` // already stored (or twice in the file)
+	Rejected   int           `json:"rejected"`
+	Errors     []ImportError `json:"errors,omitempty"` // first rejected rows
+}
+
+func (res *ImportResult) reject(line int, err error) {
+	res.Rejected++
+	if len(res.Errors) < maxImportErrors {
+		res.Errors = append(res.Errors, ImportError{Line: line, Error: err.Error()})
+	}
+}
+
+// Import validates the rows of an export into reviews of opt.AppID/Country,
+// skips the ids already stored and appends the rest, never alongside a poll
+// or backfill of the same app
+func (m *Manager) Import(ctx context.Context, r io.Reader, opt ImportOptions) (ImportResult, error) {
+	opt.Country = strings.ToLower(strings.TrimSpace(opt.Country))
+	k := storeKey(opt.AppID, opt.Country)
+	res := ImportResult{ID: k}
+	if opt.AppID == "" || opt.Country == "" {
+		return res, errors.New("appId and country are required")
+	}
+	if err := validKey(opt.AppID, opt.Country); err != nil {
+		return res, err
+	}
+	var next func() (map[string]string, int, error)
+	switch opt.Format {
+	case ImportCSV:
+		next = csvRows(r)
+	case ImportJSONL:
+		next = jsonlRows(r)
+	default:
+		return res, fmt.Errorf("format must be %q or %q, got %q", ImportCSV, ImportJSONL, opt.Format)
+	}
+
+	if err := m.claim(ctx, k); err != nil {
+		return res, err
+	}
+	defer m.release(k)
+
+	app, ok := m.App(opt.AppID, opt.Country)
+	if !ok {
+		app = AppConfig{AppID: opt.AppID, Country: opt.Country}
+	}
+	seen := m.store.GetSeenSet(opt.AppID, opt.Country)
+	now := time.Now().UTC()
+	batch := []Review{}
+	ids := []string{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := m.store.AppendReviews(opt.AppID, opt.Country, batch, ids); err != nil {
+			return err
+		}
+		res.Accepted += len(batch)
+		batch, ids = batch[:0], ids[:0]
+		return nil
+	}
+	for {
+		row, line, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var pe *rowError
+			if errors.As(err, &pe) {
+				res.Rows++
+				res.reject(line, pe.err)
+				continue
+			}
+			return res, err // unreadable input: stop here
+		}
+		res.Rows++
+		rv, err := reviewFromRow(row, opt)
+		if err != nil {
+			res.reject(line, err)
+			continue
+		}
+		if m.cfg.expired(app, rv, now) {
+			res.reject(line, fmt.Errorf("submittedAt %s is outside the retention window", rv.SubmittedAt.Format(time.RFC3339)))
+			continue
+		}
+		if _, ok := seen[rv.ID]; ok {
+			res.Duplicates++
+			continue
+		}
+		seen[rv.ID] = struct{}{}
+		batch = append(batch, rv)
+		ids = append(ids, rv.ID)
+		if len(batch) >= importBatch {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return res, err
+	}
+	return res, ctx.Err()
+}
+
+// rowError: a row that can't be read, but the next ones can
+type rowError struct{ err error }
+
+func (e *rowError) Error() string { return e.err.Error() }
+
+// csvRows yields the rows of a CSV with a header, keyed by column name
+func csvRows(r io.Reader) func() (map[string]string, int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var header []string
+	return func() (map[string]string, int, error) {
+		if header == nil {
+			h, err := cr.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, 0, io.EOF
+				}
+				return nil, 1, fmt.Errorf("csv header: %w", err)
+			}
+			for i := range h {
+				h[i] = strings.TrimSpace(strings.TrimPrefix(h[i], "\ufeff"))
+			}
+			header = h
+		}
+		rec, err := cr.Read()
+		if err != nil {
+			// FieldPos is only valid after a successful Read
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				return nil, pe.StartLine, &rowError{err}
+			}
+			return nil, 0, err
+		}
+		line, _ := cr.FieldPos(0)
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(rec) {
+				row[col] = rec[i]
+			}
+		}
+		return row, line, nil
+	}
+}
+
+// jsonlRows yields the objects of a JSONL file, values as strings
+func jsonlRows(r io.Reader) func() (map[string]string, int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	return func() (map[string]string, int, error) {
+		for sc.Scan() {
+			line++
+			if strings.TrimSpace(sc.Text()) == "" {
+				continue
+			}
+			var obj map[string]any
+			if err := json.Unmarshal(sc.Bytes(), &obj); err != nil {
+				return nil, line, &rowError{fmt.Errorf("invalid JSON: %w", err)}
+			}
+			row := make(map[string]string, len(obj))
+			for k, v := range obj {
+				switch v := v.(type) {
+				case nil:
+				case string:
+					row[k] = v
+				case float64:
+					row[k] = strconv.FormatFloat(v, 'f', -1, 64)
+				default:
+					b, _ := json.Marshal(v)
+					row[k] = string(b)
+				}
+			}
+			return row, line, nil
+		}
+		if err := sc.Err(); err != nil {
+			return nil, line, err
+		}
+		return nil, line, io.EOF
+	}
+}
+
+// importTimeLayouts: what exports use for dates, besides unix seconds
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseImportTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("submittedAt %q: not a date (RFC3339, YYYY-MM-DD[ HH:MM:SS] or unix seconds)", s)
+}
+
+// reviewFromRow maps and validates one row
+func reviewFromRow(row map[string]string, opt ImportOptions) (Review, error) {
+	get := func(field string) string {
+		col := field
+		if c, ok := opt.Mapping[field]; ok {
+			col = c
+		}
+		return strings.TrimSpace(row[col])
+	}
+	atoi := func(field string) (int, error) {
+		v := get(field)
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s %q: not an integer", field, v)
+		}
+		return n, nil
+	}
+
+	r := Review{
+		ID:          get("id"),
+		AppID:       opt.AppID,
+		Country:     opt.Country,
+		Author:      get("author"),
+		AuthorURI:   get("authorUri"),
+		Title:       get("title"),
+		Content:     get("content"),
+		ContentType: get("contentType"),
+		AppVersion:  get("appVersion"),
+		Link:        get("link"),
+		Origin:      OriginImport,
+	}
+	if r.ID == "" {
+		return r, errors.New("id is missing")
+	}
+	if a := get("appId"); a != "" && a != opt.AppID {
+		return r, fmt.Errorf("appId %q: importing into %s", a, opt.AppID)
+	}
+	if c := get("country"); c != "" && !strings.EqualFold(c, opt.Country) {
+		return r, fmt.Errorf("country %q: importing into %s", c, opt.Country)
+	}
+	var err error
+	if r.Rating, err = atoi("rating"); err != nil {
+		return r, err
+	}
+	if r.Rating < 1 || r.Rating > 5 {
+		return r, fmt.Errorf("rating %q: must be 1..5", get("rating"))
+	}
+	if r.VoteSum, err = atoi("voteSum"); err != nil {
+		return r, err
+	}
+	if r.VoteCount, err = atoi("voteCount"); err != nil {
+		return r, err
+	}
+	if r.Title == "" && r.Content == "" {
+		return r, errors.New("title and content are both empty")
+	}
+	ts := get("submittedAt")
+	if ts == "" {
+		return r, errors.New("submittedAt is missing")
+	}
+	if r.SubmittedAt, err = parseImportTime(ts); err != nil {
+		return r, err
+	}
+	if r.SubmittedAt.After(time.Now().Add(24 * time.Hour)) {
+		return r, fmt.Errorf("submittedAt %s is in the future", ts)
+	}
+	return r, nil
+}
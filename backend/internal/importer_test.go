@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// a row the csv reader can't parse is reported with its line, not a panic
+func TestCSVRowsMalformedRow(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		line  int
+	}{
+		{"unterminated quote in the first row", "id,rating\n\"r1,5\n", 2},
+		{"bare quote after a good row", "id,rating\nr1,5\nr\"2,4\n", 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := csvRows(strings.NewReader(tc.input))
+			for {
+				_, line, err := next()
+				if errors.Is(err, io.EOF) {
+					t.Fatal("reached EOF without a row error")
+				}
+				if err == nil {
+					continue
+				}
+				var re *rowError
+				if !errors.As(err, &re) {
+					t.Fatalf("got %v, want a rowError", err)
+				}
+				if line != tc.line {
+					t.Fatalf("line = %d, want %d", line, tc.line)
+				}
+				return
+			}
+		})
+	}
+}
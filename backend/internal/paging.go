@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPageLimit caps ?limit= on GET /reviews
+const maxPageLimit = 1000
+
+// reviewLess is the order of GET /reviews for a sort: total (ties broken by
+// date, then id), so a cursor always points at one place
+func reviewLess(order string) func(a, b Review) bool {
+	newer := func(a, b Review) bool {
+		if !a.SubmittedAt.Equal(b.SubmittedAt) {
+			return a.SubmittedAt.After(b.SubmittedAt)
+		}
+		return a.ID > b.ID
+	}
+	switch order {
+	case SortOldest:
+		return func(a, b Review) bool { return newer(b, a) }
+	case SortRating:
+		return func(a, b Review) bool {
+			if a.Rating != b.Rating {
+				return a.Rating < b.Rating
+			}
+			return newer(a, b)
+		}
+	default:
+		return newer
+	}
+}
+
+func sortReviews(revs []Review, order string) {
+	less := reviewLess(order)
+	sort.SliceStable(revs, func(i, j int) bool { return less(revs[i], revs[j]) })
+}
+
+// reviewCursor points at the last review of a page: the next page starts
+// right after it in the order of Sort
+type reviewCursor struct {
+	Sort   string
+	At     time.Time
+	Rating int
+	ID     string
+}
+
+// encodeCursor: opaque to clients, base64url("<sort>:<unix nanos>:<rating>:<id>")
+func encodeCursor(order string, r Review) string {
+	raw := strings.Join([]string{order, strconv.FormatInt(r.SubmittedAt.UnixNano(), 10), strconv.Itoa(r.Rating), r.ID}, ":")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+func decodeCursor(s string) (reviewCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return reviewCursor{}, errBadCursor
+	}
+	parts := strings.SplitN(string(b), ":", 4)
+	if len(parts) != 4 || parts[3] == "" {
+		return reviewCursor{}, errBadCursor
+	}
+	n, err1 := strconv.ParseInt(parts[1], 10, 64)
+	rating, err2 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil {
+		return reviewCursor{}, errBadCursor
+	}
+	return reviewCursor{Sort: parts[0], At: time.Unix(0, n).UTC(), Rating: rating, ID: parts[3]}, nil
+}
+
+// paginate returns up to limit reviews after cur (nil = from the start) of
+// revs, sorted by order, and the cursor of the next page ("" on the last one)
+func paginate(revs []Review, order string, cur *reviewCursor, limit int) ([]Review, string) {
+	start := 0
+	if cur != nil {
+		less := reviewLess(order)
+		at := Review{ID: cur.ID, SubmittedAt: cur.At, Rating: cur.Rating}
+		start = sort.Search(len(revs), func(i int) bool { return less(at, revs[i]) })
+	}
+	end := min(start+limit, len(revs))
+	page := revs[start:end]
+	if end == len(revs) || len(page) == 0 {
+		return page, ""
+	}
+	return page, encodeCursor(order, page[len(page)-1])
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults for RetryPolicy / PollPolicy (the values that used to be constants)
+const (
+	defaultRetryAttempts      = 3
+	defaultRetryBaseDelayMs   = 500
+	defaultRetryMaxJitterMs   = 200
+	defaultPageTimeoutSeconds = 15
+	defaultPageDelayMs        = 300
+	defaultMaxPages           = 10
+)
+
+// retryParams is a RetryPolicy resolved for one app
+type retryParams struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxJitter time.Duration
+}
+
+// pollParams is a PollPolicy resolved for one app
+type pollParams struct {
+	PageTimeout time.Duration
+	PageDelay   time.Duration
+	MaxPages    int
+}
+
+func intOr(p *int, def int) int {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+func intPtr(n int) *int { return &n }
+
+// withDefaults fills unset fields from def
+func (p RetryPolicy) withDefaults(def RetryPolicy) RetryPolicy {
+	if p.Attempts == nil {
+		p.Attempts = def.Attempts
+	}
+	if p.BaseDelayMs == nil {
+		p.BaseDelayMs = def.BaseDelayMs
+	}
+	if p.MaxJitterMs == nil {
+		p.MaxJitterMs = def.MaxJitterMs
+	}
+	return p
+}
+
+func (p PollPolicy) withDefaults(def PollPolicy) PollPolicy {
+	if p.PageTimeoutSeconds == nil {
+		p.PageTimeoutSeconds = def.PageTimeoutSeconds
+	}
+	if p.PageDelayMs == nil {
+		p.PageDelayMs = def.PageDelayMs
+	}
+	if p.MaxPages == nil {
+		p.MaxPages = def.MaxPages
+	}
+	return p
+}
+
+func (p RetryPolicy) validate() error {
+	if n := intOr(p.Attempts, 1); n < 1 || n > 10 {
+		return fmt.Errorf("retry.attempts must be 1..10, got %d", n)
+	}
+	if n := intOr(p.BaseDelayMs, 0); n < 0 {
+		return fmt.Errorf("retry.baseDelayMs must be >= 0, got %d", n)
+	}
+	if n := intOr(p.MaxJitterMs, 0); n < 0 {
+		return fmt.Errorf("retry.maxJitterMs must be >= 0, got %d", n)
+	}
+	return nil
+}
+
+func (p PollPolicy) validate() error {
+	if n := intOr(p.PageTimeoutSeconds, 1); n < 1 {
+		return fmt.Errorf("poll.pageTimeoutSeconds must be >= 1, got %d", n)
+	}
+	if n := intOr(p.PageDelayMs, 0); n < 0 {
+		return fmt.Errorf("poll.pageDelayMs must be >= 0, got %d", n)
+	}
+	if n := intOr(p.MaxPages, 1); n < 1 {
+		return fmt.Errorf("poll.maxPages must be >= 1, got %d", n)
+	}
+	return nil
+}
+
+// applyPolicyDefaults fills the global policies and validates every override
+func (c *Config) applyPolicyDefaults() error {
+	c.Retry = c.Retry.withDefaults(RetryPolicy{
+		Attempts:    intPtr(defaultRetryAttempts),
+		BaseDelayMs: intPtr(defaultRetryBaseDelayMs),
+		MaxJitterMs: intPtr(defaultRetryMaxJitterMs),
+	})
+	c.Poll = c.Poll.withDefaults(PollPolicy{
+		PageTimeoutSeconds: intPtr(defaultPageTimeoutSeconds),
+		PageDelayMs:        intPtr(defaultPageDelayMs),
+		MaxPages:           intPtr(defaultMaxPages),
+	})
+	if err := c.Retry.validate(); err != nil {
+		return err
+	}
+	if err := c.Poll.validate(); err != nil {
+		return err
+	}
+	for _, a := range c.Apps {
+		if a.Retry != nil {
+			if err := a.Retry.validate(); err != nil {
+				return fmt.Errorf("app %s-%s: %w", a.AppID, a.Country, err)
+			}
+		}
+		if a.Poll != nil {
+			if err := a.Poll.validate(); err != nil {
+				return fmt.Errorf("app %s-%s: %w", a.AppID, a.Country, err)
+			}
+		}
+		if a.RetentionDays != nil && *a.RetentionDays < 0 {
+			return fmt.Errorf("app %s-%s: retentionDays must be >= 0, got %d", a.AppID, a.Country, *a.RetentionDays)
+		}
+	}
+	return nil
+}
+
+// retryFor merges the app override (if any) over the global retry policy
+func (c *Config) retryFor(app AppConfig) retryParams {
+	p := c.Retry
+	if app.Retry != nil {
+		p = app.Retry.withDefaults(c.Retry)
+	}
+	return retryParams{
+		Attempts:  intOr(p.Attempts, defaultRetryAttempts),
+		BaseDelay: time.Duration(intOr(p.BaseDelayMs, defaultRetryBaseDelayMs)) * time.Millisecond,
+		MaxJitter: time.Duration(intOr(p.MaxJitterMs, defaultRetryMaxJitterMs)) * time.Millisecond,
+	}
+}
+
+// pollFor merges the app override (if any) over the global poll policy
+func (c *Config) pollFor(app AppConfig) pollParams {
+	p := c.Poll
+	if app.Poll != nil {
+		p = app.Poll.withDefaults(c.Poll)
+	}
+	return pollParams{
+		PageTimeout: time.Duration(intOr(p.PageTimeoutSeconds, defaultPageTimeoutSeconds)) * time.Second,
+		PageDelay:   time.Duration(intOr(p.PageDelayMs, defaultPageDelayMs)) * time.Millisecond,
+		MaxPages:    intOr(p.MaxPages, defaultMaxPages),
+	}
+}
+
+// retentionFor: how long reviews of app are kept (0 = forever)
+func (c *Config) retentionFor(app AppConfig) time.Duration {
+	days := intOr(app.RetentionDays, c.RetentionDays)
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// expired: r is older than the retention window of app (if any)
+func (c *Config) expired(app AppConfig, r Review, now time.Time) bool {
+	ret := c.retentionFor(app)
+	return ret > 0 && r.SubmittedAt.Before(now.Add(-ret))
+}
@@ -3,32 +3,53 @@ package internal
 import (
 	"context"
 	"log"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Manager struct {
-	cfg   *Config
-	store *FileStore
+	cfg     *Config
+	store   ReviewStore
+	sources map[string]ReviewSource
 
 	mu       sync.Mutex
 	running  map[string]bool
 	breakers map[string]*CircuitBreaker
+	throttle *Throttle // shared rate limit + hold-off after 429/503
+
+	pollSlots chan struct{} // bounds concurrent PollOnce runs
+
+	backfills map[string]*BackfillStatus // key: appId-country
+	lastAlert map[string]time.Time       // last rating.anomaly per appId-country
+
+	// webhook events waiting for the webhook workers (see enqueueWebhook)
+	hooks     chan webhookPost
+	hooksStop chan struct{}
+	hooksWG   sync.WaitGroup
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
-func NewManager(cfg *Config, st *FileStore) *Manager {
+func NewManager(cfg *Config, st ReviewStore) *Manager {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Manager{
-		cfg:      cfg,
-		store:    st,
-		running:  map[string]bool{},
-		breakers: map[string]*CircuitBreaker{},
-		ctx:      ctx,
-		cancel:   cancel,
+		cfg:       cfg,
+		store:     st,
+		sources:   buildSources(cfg),
+		running:   map[string]bool{},
+		breakers:  map[string]*CircuitBreaker{},
+		throttle:  NewThrottle(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst),
+		pollSlots: make(chan struct{}, cfg.MaxConcurrentPolls),
+		backfills: map[string]*BackfillStatus{},
+		lastAlert: map[string]time.Time{},
+		hooks:     make(chan webhookPost, webhookQueueSize),
+		hooksStop: make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 }
 
@@ -60,12 +81,23 @@ func (m *Manager) Start() {
 		m.wg.Add(1)
 		go m.worker(m.ctx, app)
 	}
+	if h := m.cfg.CompactIntervalHours; h > 0 {
+		m.wg.Add(1)
+		go m.compactor(m.ctx, time.Duration(h)*time.Hour)
+	}
+	for range webhookWorkers {
+		m.hooksWG.Add(1)
+		go m.webhookWorker()
+	}
 }
 
-// Stop shuts down everything with ctx
+// Stop shuts down everything with ctx, then lets the webhook workers send
+// what the last polls queued
 func (m *Manager) Stop() {
 	m.cancel()
 	m.wg.Wait()
+	close(m.hooksStop)
+	m.hooksWG.Wait()
 }
 
 func (m *Manager) worker(ctx context.Context, app AppConfig) {
@@ -105,40 +137,104 @@ func (m *Manager) PollOnce(ctx context.Context, app AppConfig) {
 		m.mu.Unlock()
 	}()
 
+	// wait for a free slot of the worker pool
+	select {
+	case m.pollSlots <- struct{}{}:
+		defer func() { <-m.pollSlots }()
+	case <-ctx.Done():
+		return
+	}
+
 	if !cb.Allow() {
 		log.Printf("[poll %s] circuit breaker OPEN (%s), skipping iteration", k, cb.State())
 		return
 	}
 
+	src, err := m.sourceFor(app)
+	if err != nil {
+		log.Printf("[poll %s] %v", k, err)
+		return
+	}
+
 	seen := m.store.GetSeenSet(app.AppID, app.Country)
+	marks := m.store.RevisionMarks(app.AppID, app.Country)
+	// the first poll stores the whole feed: not news for review.created
+	_, polledBefore := m.store.LastPoll(app.AppID, app.Country)
+	created := []Review{}
+	edits := 0
 
 	newTotal := 0
+	restored := 0
 	newIDs := []string{}
 	toAppend := []Review{}
+	fetched := []Review{} // everything the feed returned, for reconcile
+	formats := []string{} // decoded format per page, for the poll log
+	validators := map[int]PageValidator{}
+	notModified := false
 
-	const maxPages = 10
+	pp := m.cfg.pollFor(app)
+	maxPages := min(pp.MaxPages, src.Pages(app))
 	for page := 1; page <= maxPages; page++ {
 		// Per-page context (to avoid long blocks)
-		pageCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
-		revs, err := FetchPageWithRetry(pageCtx, m.cfg, app.Country, app.AppID, page)
+		pageCtx, cancel := context.WithTimeout(ctx, pp.PageTimeout)
+		cond := m.store.PageValidator(app.AppID, app.Country, page)
+		fp, err := FetchPageWithRetry(pageCtx, m.cfg, src, m.throttle, app, page, cond)
 		cancel()
 
+		if err != nil && isRateLimited(err) {
+			// Apple is throttling us: the shared Throttle already defers
+			// every worker, don't count it against this app's breaker
+			log.Printf("[poll %s] page %d rate limited, holding off until %s: %v", k, page, m.throttle.Until().Format(time.RFC3339), err)
+			return
+		}
 		if err != nil {
 			// ITERATION FAILURE: report to CB and break
 			cb.Failure()
 			log.Printf("[poll %s] fetch page %d failed after retries: %v", k, page, err)
 			return
 		}
-		// success on this page → report to CB
+		// success on this page → report to CB (a 304 is a success too)
 		cb.Success()
+		if fp.NotModified {
+			// unchanged page => nothing new here nor on older pages
+			notModified = true
+			break
+		}
+		validators[page] = fp.Validator
+		if fp.Format != "" {
+			formats = append(formats, fp.Format)
+		}
+		if app.FeedFormat() == FormatAuto && fp.Format == FormatXML {
+			log.Printf("[poll %s] page %d: JSON payload unusable, decoded Atom XML instead", k, page)
+		}
 
-		if len(revs) == 0 {
+		if len(fp.Reviews) == 0 {
 			break
 		}
 
+		fetched = append(fetched, fp.Reviews...)
 		pageNew := 0
-		for _, r := range revs {
+		now := time.Now().UTC()
+		for _, r := range fp.Reviews {
+			if m.cfg.expired(app, r, now) {
+				continue // compaction would drop it again
+			}
 			if _, ok := seen[r.ID]; ok {
+				// already stored: keep it only if the user edited it
+				// (or it is back after being marked as removed)
+				mk, known := marks[r.ID]
+				if h := reviewHash(r); known && h != mk.Hash {
+					r.Revision = mk.Rev + 1
+					marks[r.ID] = markOf(r)
+					toAppend = append(toAppend, r)
+					edits++
+					pageNew++
+				} else if known && mk.Removed {
+					r.Revision = mk.Rev
+					marks[r.ID] = markOf(r)
+					toAppend = append(toAppend, r)
+					restored++
+				}
 				continue
 			}
 			// Consider all reviews (48h filter will be in GET /reviews)
@@ -147,6 +243,7 @@ func (m *Manager) PollOnce(ctx context.Context, app AppConfig) {
 			newTotal++
 			newIDs = append(newIDs, r.ID)
 			toAppend = append(toAppend, r)
+			created = append(created, r)
 		}
 
 		// Early stop: if no new items found here, older ones follow
@@ -155,20 +252,63 @@ func (m *Manager) PollOnce(ctx context.Context, app AppConfig) {
 		}
 
 		// Short pause between pages for rate limiting
-		time.Sleep(300 * time.Millisecond)
+		time.Sleep(pp.PageDelay)
 	}
 
-	if newTotal > 0 {
+	if len(toAppend) > 0 {
 		if err := m.store.AppendReviews(app.AppID, app.Country, toAppend, newIDs); err != nil {
 			log.Printf("[poll %s] append error: %v", k, err)
-		} else {
-			log.Printf("[poll %s] appended %d new reviews", k, newTotal)
+			return
+		}
+		log.Printf("[poll %s] appended %d new reviews, %d edited, %d restored (format %s)", k, newTotal, edits, restored, formatSummary(formats))
+		if polledBefore && len(created) > 0 {
+			m.notifyReviewsCreated(app, created)
 		}
 	} else {
 		// update lastPoll only
 		_ = m.store.AppendReviews(app.AppID, app.Country, nil, nil)
-		log.Printf("[poll %s] no new reviews", k)
+		if notModified && len(formats) == 0 {
+			log.Printf("[poll %s] no new reviews (not modified)", k)
+		} else {
+			log.Printf("[poll %s] no new reviews (format %s)", k, formatSummary(formats))
+		}
+	}
+	if err := m.store.SavePageValidators(app.AppID, app.Country, validators); err != nil {
+		log.Printf("[poll %s] saving page validators: %v", k, err)
+	}
+
+	if n, err := m.reconcile(app, fetched); err != nil {
+		log.Printf("[poll %s] reconcile: %v", k, err)
+	} else if n > 0 {
+		log.Printf("[poll %s] marked %d reviews as removed", k, n)
+	}
+	if len(toAppend) > 0 {
+		m.checkAnomaly(app)
 	}
 }
 
+// formatSummary compacts per-page formats for logs: "json", "json+xml", ...
+func formatSummary(formats []string) string {
+	if len(formats) == 0 {
+		return "n/a"
+	}
+	out := []string{}
+	for _, f := range formats {
+		if !slices.Contains(out, f) {
+			out = append(out, f)
+		}
+	}
+	return strings.Join(out, "+")
+}
+
 func (m *Manager) Apps() []AppConfig { return m.cfg.Apps }
+
+// App returns the configured entry for appId/country, if any
+func (m *Manager) App(appID, country string) (AppConfig, bool) {
+	for _, a := range m.cfg.Apps {
+		if a.AppID == appID && a.Country == country {
+			return a, true
+		}
+	}
+	return AppConfig{}, false
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"log"
+	"time"
+)
+
+// reconcileMinSuspicious: below this many missing reviews we always trust the feed
+const reconcileMinSuspicious = 5
+
+// reconcile compares the reviews just fetched with the stored ones in the
+// same time window (oldest fetched review .. now): stored reviews that are
+// no longer in the feed were removed by Apple (moderation, user deletion)
+// and get a removal marker. Imported reviews are left alone: most were never
+// in the feed. Returns how many were marked.
+func (m *Manager) reconcile(app AppConfig, fetched []Review) (int, error) {
+	if len(fetched) == 0 {
+		return 0, nil // nothing fetched (304 or empty page): no window to compare
+	}
+	inFeed := make(map[string]struct{}, len(fetched))
+	oldest := fetched[0].SubmittedAt
+	for _, r := range fetched {
+		inFeed[r.ID] = struct{}{}
+		if r.SubmittedAt.Before(oldest) {
+			oldest = r.SubmittedAt
+		}
+	}
+
+	now := time.Now().UTC()
+	stored, err := m.store.ReadRecent(app.AppID, app.Country, now.Sub(oldest))
+	if err != nil {
+		return 0, err
+	}
+	gone := []Review{}
+	window := 0
+	for _, r := range stored {
+		// strictly newer than the oldest fetched: ties may sit on the next page
+		if !r.SubmittedAt.After(oldest) || r.Removed() || r.Origin == OriginImport {
+			continue
+		}
+		window++
+		if _, ok := inFeed[r.ID]; !ok {
+			r.RemovedAt = now
+			gone = append(gone, r)
+		}
+	}
+	if len(gone) == 0 {
+		return 0, nil
+	}
+	// a feed glitch (short/partial page) must not wipe the store
+	if len(gone) > reconcileMinSuspicious && len(gone)*2 > window {
+		log.Printf("[reconcile %s-%s] %d of %d stored reviews missing from the feed, looks like a glitch: skipped", app.AppID, app.Country, len(gone), window)
+		return 0, nil
+	}
+	if err := m.store.AppendReviews(app.AppID, app.Country, gone, nil); err != nil {
+		return 0, err
+	}
+	return len(gone), nil
+}
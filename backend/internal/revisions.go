@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+)
+
+// RevisionMark: content hash and revision number of the latest stored copy
+type RevisionMark struct {
+	Hash    string `json:"h"`
+	Rev     int    `json:"r,omitempty"`
+	Removed bool   `json:"x,omitempty"` // latest copy is a removal marker
+}
+
+func markOf(r Review) RevisionMark {
+	return RevisionMark{Hash: reviewHash(r), Rev: r.Revision, Removed: r.Removed()}
+}
+
+// reviewHash covers what a user can change when editing a review. Not the
+// app version: rows stored before it was kept (or imported) have none, and
+// they would all look edited.
+func reviewHash(r Review) string {
+	h := sha256.New()
+	for _, part := range []string{strconv.Itoa(r.Rating), r.Title, r.Content} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// RevisionMarks returns (a copy of) the latest revision marks of an app
+func (s *FileStore) RevisionMarks(appID, country string) map[string]RevisionMark {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.indexFor(appID, country)
+	out := make(map[string]RevisionMark, len(idx))
+	for id, m := range idx {
+		out[id] = m
+	}
+	return out
+}
+
+// History returns every stored revision of a review, oldest first
+func (s *FileStore) History(appID, country, id string) ([]Review, error) {
+	byRev := map[int]Review{}
+	err := s.scanReviews(appID, country, func(r Review) {
+		if r.ID == id {
+			byRev[r.Revision] = r // same revision twice: last row wins
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Review, 0, len(byRev))
+	for _, r := range byRev {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Revision < out[j].Revision })
+	return out, nil
+}
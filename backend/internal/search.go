@@ -0,0 +1,504 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Query syntax of GET /search:
+//
+//	crash login          both words (AND is implicit)
+//	crash OR freeze      either
+//	crash -login         crash but not login (also: NOT login)
+//	"dark mode"          phrase: the words next to each other
+//	(crash OR freeze) AND ipad
+//
+// Words are matched after the same folding and stemming as the reviews.
+
+// Searcher is implemented by stores that keep a search index
+type Searcher interface {
+	// Search returns the best limit matches of q in an app and how many
+	// reviews match in total
+	Search(appID, country string, q *SearchQuery, limit int) ([]SearchHit, int, error)
+}
+
+var (
+	_ Searcher = (*FileStore)(nil)
+	_ Searcher = (*SQLStore)(nil)
+)
+
+// SearchHit: a matching review, its BM25 score and the matches in <mark>
+// (Title and Snippet are HTML: the review text is escaped)
+type SearchHit struct {
+	Score   float64 `json:"score"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Review  Review  `json:"review"`
+}
+
+// SearchQuery is a parsed query; words are stemmed per index language on use
+type SearchQuery struct {
+	root queryNode
+}
+
+type queryNode struct {
+	op    string // word, phrase, and, or, not
+	words []string
+	kids  []queryNode
+}
+
+// ParseSearchQuery parses the syntax above
+func ParseSearchQuery(s string) (*SearchQuery, error) {
+	toks, err := lexQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	if len(toks) == 0 {
+		return nil, errors.New("empty query")
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(toks) {
+		return nil, fmt.Errorf("unexpected %q", toks[p.i].text)
+	}
+	if root.op == "" {
+		return nil, errors.New("nothing to search for")
+	}
+	return &SearchQuery{root: root}, nil
+}
+
+type queryTok struct {
+	kind string // word, phrase, op, ( )
+	text string
+}
+
+func lexQuery(s string) ([]queryTok, error) {
+	toks := []queryTok{}
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			toks = append(toks, queryTok{kind: string(r), text: string(r)})
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			if j == len(rs) {
+				return nil, errors.New("unterminated phrase")
+			}
+			toks = append(toks, queryTok{kind: "phrase", text: string(rs[i+1 : j])})
+			i = j + 1
+		case r == '-' && i+1 < len(rs) && !unicode.IsSpace(rs[i+1]) && (i == 0 || unicode.IsSpace(rs[i-1]) || rs[i-1] == '('):
+			toks = append(toks, queryTok{kind: "op", text: "NOT"})
+			i++
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && rs[j] != '(' && rs[j] != ')' && rs[j] != '"' {
+				j++
+			}
+			w := string(rs[i:j])
+			if w == "AND" || w == "OR" || w == "NOT" {
+				toks = append(toks, queryTok{kind: "op", text: w})
+			} else {
+				toks = append(toks, queryTok{kind: "word", text: w})
+			}
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []queryTok
+	i    int
+}
+
+func (p *queryParser) peek() (queryTok, bool) {
+	if p.i < len(p.toks) {
+		return p.toks[p.i], true
+	}
+	return queryTok{}, false
+}
+
+// termNext: the next token can start a term (not AND/OR, ")" or the end)
+func (p *queryParser) termNext() bool {
+	t, ok := p.peek()
+	return ok && t.kind != ")" && (t.kind != "op" || t.text == "NOT")
+}
+
+func (p *queryParser) or() (queryNode, error) {
+	n, err := p.and()
+	if err != nil {
+		return n, err
+	}
+	kids := []queryNode{}
+	if n.op != "" {
+		kids = append(kids, n)
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != "op" || t.text != "OR" {
+			break
+		}
+		p.i++
+		n, err := p.and()
+		if err != nil {
+			return n, err
+		}
+		if n.op != "" {
+			kids = append(kids, n)
+		}
+	}
+	switch len(kids) {
+	case 0:
+		return queryNode{}, nil
+	case 1:
+		return kids[0], nil
+	}
+	return queryNode{op: "or", kids: kids}, nil
+}
+
+func (p *queryParser) and() (queryNode, error) {
+	kids := []queryNode{}
+	start := p.i
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == ")" || (t.kind == "op" && t.text == "OR") {
+			break
+		}
+		if t.kind == "op" && t.text == "AND" {
+			p.i++
+			if len(kids) == 0 || !p.termNext() {
+				return queryNode{}, errors.New("AND needs a term on both sides")
+			}
+			continue
+		}
+		n, err := p.unary()
+		if err != nil {
+			return n, err
+		}
+		if n.op != "" {
+			kids = append(kids, n)
+		}
+	}
+	switch {
+	case len(kids) == 0 && p.i > start:
+		return queryNode{}, nil // only words with nothing to search
+	case len(kids) == 0:
+		if t, ok := p.peek(); ok {
+			return queryNode{}, fmt.Errorf("%q needs a term before it", t.text)
+		}
+		return queryNode{}, errors.New("query ends with an operator")
+	case len(kids) == 1:
+		return kids[0], nil
+	}
+	return queryNode{op: "and", kids: kids}, nil
+}
+
+// unary returns a zero node for a word with nothing to search (e.g. "!!")
+func (p *queryParser) unary() (queryNode, error) {
+	t, _ := p.peek()
+	p.i++
+	switch t.kind {
+	case "op": // NOT (AND/OR are handled above)
+		if !p.termNext() {
+			return queryNode{}, errors.New("NOT needs a term after it")
+		}
+		n, err := p.unary()
+		if err != nil || n.op == "" {
+			return n, err
+		}
+		return queryNode{op: "not", kids: []queryNode{n}}, nil
+	case "(":
+		n, err := p.or()
+		if err != nil {
+			return n, err
+		}
+		if t, ok := p.peek(); !ok || t.kind != ")" {
+			return n, errors.New("missing )")
+		}
+		p.i++
+		return n, nil
+	case ")":
+		return queryNode{}, errors.New("unexpected )")
+	}
+	// a word like "wi-fi" is a phrase of its parts; stemmed on use
+	words := strings.FieldsFunc(t.text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	switch {
+	case len(words) == 0:
+		return queryNode{}, nil
+	case len(words) == 1 && t.kind == "word":
+		return queryNode{op: "word", words: words}, nil
+	}
+	return queryNode{op: "phrase", words: words}, nil
+}
+
+// BM25 parameters
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	titleWeight = 2 // a match in the title counts as two
+)
+
+// docScores: matching docs with their score
+type docScores map[int32]float64
+
+// run evaluates a query on an index
+func (ix *searchIndex) run(q *SearchQuery) docScores {
+	return ix.eval(q.root)
+}
+
+func (ix *searchIndex) eval(n queryNode) docScores {
+	switch n.op {
+	case "word", "phrase":
+		return ix.match(n.words)
+	case "or":
+		out := docScores{}
+		for _, k := range n.kids {
+			for d, s := range ix.eval(k) {
+				out[d] += s
+			}
+		}
+		return out
+	case "not":
+		return ix.complement(ix.eval(n.kids[0]))
+	case "and":
+		var out docScores
+		excl := []docScores{}
+		for _, k := range n.kids {
+			if k.op == "not" {
+				excl = append(excl, ix.eval(k.kids[0]))
+				continue
+			}
+			m := ix.eval(k)
+			if out == nil {
+				out = m
+				continue
+			}
+			for d := range out {
+				if s, ok := m[d]; ok {
+					out[d] += s
+				} else {
+					delete(out, d)
+				}
+			}
+		}
+		if out == nil {
+			out = ix.complement(nil) // only NOTs
+		}
+		for _, e := range excl {
+			for d := range e {
+				delete(out, d)
+			}
+		}
+		return out
+	}
+	return docScores{}
+}
+
+// complement: every live doc not in m, scoring 0
+func (ix *searchIndex) complement(m docScores) docScores {
+	out := docScores{}
+	for _, d := range ix.ByID {
+		if ix.Docs[d].Dead {
+			continue // removed review
+		}
+		if _, ok := m[d]; !ok {
+			out[d] = 0
+		}
+	}
+	return out
+}
+
+// match finds the docs with words at consecutive positions (one word: the
+// docs containing it), scored with BM25
+func (ix *searchIndex) match(words []string) docScores {
+	stems := make([]string, len(words))
+	for i, w := range words {
+		ts := terms(w, ix.Lang)
+		if len(ts) != 1 {
+			return docScores{}
+		}
+		stems[i] = ts[0]
+	}
+	// tf per doc, title matches weighted
+	tf := map[int32]float64{}
+	first := ix.Postings[stems[0]]
+	rest := make([]map[int32][]int32, len(stems)-1)
+	for i, st := range stems[1:] {
+		rest[i] = map[int32][]int32{}
+		for _, p := range ix.Postings[st] {
+			rest[i][p.Doc] = p.Pos
+		}
+	}
+	for _, p := range first {
+		doc := &ix.Docs[p.Doc]
+		if doc.Dead {
+			continue
+		}
+		for _, pos := range p.Pos {
+			ok := true
+			for i := range rest {
+				if !containsPos(rest[i][p.Doc], pos+int32(i+1)) {
+					ok = false
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+			if pos < doc.TitleLen {
+				tf[p.Doc] += titleWeight
+			} else {
+				tf[p.Doc]++
+			}
+		}
+	}
+	out := docScores{}
+	if len(tf) == 0 {
+		return out
+	}
+	n := float64(ix.Live)
+	idf := math.Log(1 + (n-float64(len(tf))+0.5)/(float64(len(tf))+0.5))
+	avg := float64(ix.TotalLen) / math.Max(n, 1)
+	for d, f := range tf {
+		norm := 1 - bm25B + bm25B*float64(ix.Docs[d].Len)/math.Max(avg, 1)
+		out[d] = idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+	}
+	return out
+}
+
+func containsPos(ps []int32, p int32) bool {
+	i := sort.Search(len(ps), func(i int) bool { return ps[i] >= p })
+	return i < len(ps) && ps[i] == p
+}
+
+// highlightTerms: the stems of the words the query looks for (not the excluded ones)
+func (q *SearchQuery) highlightTerms(lang string) map[string]bool {
+	out := map[string]bool{}
+	var walk func(n queryNode)
+	walk = func(n queryNode) {
+		switch n.op {
+		case "not":
+			return
+		case "word", "phrase":
+			for _, w := range n.words {
+				for _, t := range terms(w, lang) {
+					out[t] = true
+				}
+			}
+		}
+		for _, k := range n.kids {
+			walk(k)
+		}
+	}
+	walk(q.root)
+	return out
+}
+
+// snippetWords: how much of the content a snippet shows, in words
+const snippetWords = 30
+
+// highlight escapes text and wraps its matching words in <mark>; with
+// window > 0 only the window words around the most matches are kept
+func highlight(text, lang string, hl map[string]bool, window int) string {
+	toks := tokenize(text, lang)
+	from, to := 0, len(toks)
+	if window > 0 && len(toks) > window {
+		best, hits := 0, 0
+		for i := range toks {
+			if !hl[toks[i].term] {
+				continue
+			}
+			n := 0
+			for j := i; j < len(toks) && j < i+window; j++ {
+				if hl[toks[j].term] {
+					n++
+				}
+			}
+			if n > hits {
+				best, hits = i, n
+			}
+		}
+		// a few words of context before the first match
+		from = max(0, min(best-3, len(toks)-window))
+		to = from + window
+	}
+	var b strings.Builder
+	start, end := 0, len(text)
+	// cut at spaces, not inside "I've"
+	if from > 0 {
+		start = strings.LastIndexFunc(text[:toks[from].start], unicode.IsSpace) + 1
+		b.WriteString("… ")
+	}
+	if to < len(toks) {
+		end = toks[to-1].end
+		if i := strings.IndexFunc(text[end:], unicode.IsSpace); i >= 0 {
+			end += i
+		} else {
+			end = len(text)
+		}
+	}
+	at := start
+	for _, t := range toks[from:to] {
+		if !hl[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[at:t.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[t.start:t.end]) + "</mark>")
+		at = t.end
+	}
+	b.WriteString(html.EscapeString(text[at:end]))
+	if to < len(toks) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
+
+// search runs q on the index of an app: the best limit hits (score, then
+// newest first) and the number of matches
+func (si *searchIndexes) search(appID, country string, q *SearchQuery, limit int) ([]SearchHit, int, error) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	ix, err := si.get(appID, country)
+	if err != nil {
+		return nil, 0, err
+	}
+	scores := ix.run(q)
+	docs := make([]int32, 0, len(scores))
+	for d := range scores {
+		docs = append(docs, d)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		a, b := docs[i], docs[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return ix.Docs[a].Review.SubmittedAt.After(ix.Docs[b].Review.SubmittedAt)
+	})
+	hl := q.highlightTerms(ix.Lang)
+	hits := make([]SearchHit, 0, min(limit, len(docs)))
+	for _, d := range docs[:min(limit, len(docs))] {
+		r := ix.Docs[d].Review
+		hits = append(hits, SearchHit{
+			Score:   math.Round(scores[d]*1000) / 1000,
+			Title:   highlight(r.Title, ix.Lang, hl, 0),
+			Snippet: highlight(r.Content, ix.Lang, hl, snippetWords),
+			Review:  r,
+		})
+	}
+	return hits, len(docs), nil
+}
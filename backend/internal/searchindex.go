@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The search index of an app is an inverted index (term -> reviews and
+// positions) over the latest revision of every review, kept in memory and
+// saved to data/search/<appId>-<country>.idx.gz. It is a cache of the store:
+// it records how many store rows it covers, and one that doesn't match the
+// store (crash before a save, compaction, restore, another backend) is
+// rebuilt from it on first use.
+
+// searchIndexVersion: bump when the analysis changes, to rebuild old indexes
+// (2: removed reviews are no longer indexed)
+const searchIndexVersion = 2
+
+// searchSaveEvery: an updated index is saved at most this often (and on Close)
+const searchSaveEvery = time.Minute
+
+type posting struct {
+	Doc int32
+	Pos []int32 // title positions first, then content ones
+}
+
+type searchDoc struct {
+	Review   Review // latest revision: returned as is, text used for snippets
+	TitleLen int32  // positions < TitleLen are in the title
+	Len      int32
+	Dead     bool // replaced by a newer revision, or a removed review
+}
+
+type searchIndex struct {
+	Version  int
+	Backend  string
+	Lang     string
+	Rows     int // store rows covered
+	Docs     []searchDoc
+	ByID     map[string]int32 // latest doc of each review id (dead if removed)
+	Postings map[string][]posting
+	TotalLen int64 // of the live docs, for the average length of BM25
+	Live     int
+
+	dirty bool
+	saved time.Time
+}
+
+func newSearchIndex(backend, lang string) *searchIndex {
+	return &searchIndex{
+		Version:  searchIndexVersion,
+		Backend:  backend,
+		Lang:     lang,
+		ByID:     map[string]int32{},
+		Postings: map[string][]posting{},
+	}
+}
+
+// add indexes r, replacing an older revision of the same review. A removed
+// review only leaves a dead doc behind: it matches nothing, but older
+// revisions still can't take its place.
+func (ix *searchIndex) add(r Review) {
+	if d, ok := ix.ByID[r.ID]; ok {
+		old := &ix.Docs[d]
+		if r.Revision < old.Review.Revision {
+			return
+		}
+		if !old.Dead {
+			old.Dead = true
+			ix.TotalLen -= int64(old.Len)
+			ix.Live--
+		}
+	}
+	doc := int32(len(ix.Docs))
+	if r.Removed() {
+		ix.Docs = append(ix.Docs, searchDoc{Review: r, Dead: true})
+		ix.ByID[r.ID] = doc
+		ix.dirty = true
+		return
+	}
+	title := terms(r.Title, ix.Lang)
+	content := terms(r.Content, ix.Lang)
+	// a gap between title and content: no phrase spans both
+	all := append(append(title, ""), content...)
+	byTerm := map[string][]int32{}
+	order := []string{}
+	for i, t := range all {
+		if t == "" {
+			continue
+		}
+		if _, ok := byTerm[t]; !ok {
+			order = append(order, t)
+		}
+		byTerm[t] = append(byTerm[t], int32(i))
+	}
+	for _, t := range order {
+		ix.Postings[t] = append(ix.Postings[t], posting{Doc: doc, Pos: byTerm[t]})
+	}
+	n := int32(len(title) + len(content))
+	ix.Docs = append(ix.Docs, searchDoc{Review: r, TitleLen: int32(len(title)), Len: n})
+	ix.ByID[r.ID] = doc
+	ix.TotalLen += int64(n)
+	ix.Live++
+	ix.dirty = true
+
+	// too many replaced docs: rebuild from the live ones
+	if dead := len(ix.Docs) - ix.Live; dead > 1000 && dead > ix.Live {
+		ix.repack()
+	}
+}
+
+func (ix *searchIndex) repack() {
+	docs := ix.Docs
+	*ix = searchIndex{
+		Version: ix.Version, Backend: ix.Backend, Lang: ix.Lang, Rows: ix.Rows,
+		ByID: map[string]int32{}, Postings: map[string][]posting{},
+	}
+	for _, d := range docs {
+		if !d.Dead {
+			ix.add(d.Review)
+		}
+	}
+	ix.dirty = true
+}
+
+// indexSource: what an index is built from and checked against
+type indexSource interface {
+	// storedRows counts the rows stored for an app (every revision)
+	storedRows(appID, country string) (int, error)
+	ReadRecent(appID, country string, horizon time.Duration) ([]Review, error)
+}
+
+// searchIndexes holds the index of every app of a store. mu is taken before
+// any lock of the store, so stores update it only after their own writes.
+type searchIndexes struct {
+	dir     string
+	backend string
+	src     indexSource
+
+	mu    sync.Mutex
+	byKey map[string]*searchIndex
+}
+
+func newSearchIndexes(dir, backend string, src indexSource) *searchIndexes {
+	return &searchIndexes{dir: dir, backend: backend, src: src, byKey: map[string]*searchIndex{}}
+}
+
+func (si *searchIndexes) path(key string) string {
+	return filepath.Join(si.dir, key+".idx.gz")
+}
+
+// load reads the saved index of an app; nil if missing or not usable
+func (si *searchIndexes) load(key, lang string) *searchIndex {
+	if si.dir == "" {
+		return nil
+	}
+	f, err := os.Open(si.path(key))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[search] %s: %v", key, err)
+		}
+		return nil
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		log.Printf("[search] %s: unreadable index, rebuilding: %v", key, err)
+		return nil
+	}
+	ix := &searchIndex{}
+	if err := gob.NewDecoder(zr).Decode(ix); err != nil {
+		log.Printf("[search] %s: unreadable index, rebuilding: %v", key, err)
+		return nil
+	}
+	if ix.Version != searchIndexVersion || ix.Backend != si.backend || ix.Lang != lang {
+		return nil
+	}
+	ix.saved = time.Now()
+	return ix
+}
+
+// save writes an index to disk (no dir: kept in memory only)
+func (si *searchIndexes) save(key string, ix *searchIndex) error {
+	if si.dir == "" {
+		ix.dirty = false
+		ix.saved = time.Now()
+		return nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(zw).Encode(ix); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(si.dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(si.path(key), buf.Bytes()); err != nil {
+		return err
+	}
+	ix.dirty = false
+	ix.saved = time.Now()
+	return nil
+}
+
+// get returns the index of an app, loading or rebuilding it as needed.
+// Caller holds mu.
+func (si *searchIndexes) get(appID, country string) (*searchIndex, error) {
+	key := storeKey(appID, country)
+	rows, err := si.src.storedRows(appID, country)
+	if err != nil {
+		return nil, err
+	}
+	ix := si.byKey[key]
+	if ix == nil {
+		ix = si.load(key, langFor(country))
+	}
+	if ix != nil && ix.Rows == rows {
+		si.byKey[key] = ix
+		return ix, nil
+	}
+
+	start := time.Now()
+	revs, err := si.src.ReadRecent(appID, country, allTime)
+	if err != nil {
+		return nil, fmt.Errorf("building search index: %w", err)
+	}
+	ix = newSearchIndex(si.backend, langFor(country))
+	ix.Rows = rows
+	for _, r := range revs {
+		ix.add(r)
+	}
+	si.byKey[key] = ix
+	log.Printf("[search] %s: indexed %d reviews in %s", key, ix.Live, time.Since(start).Round(time.Millisecond))
+	if err := si.save(key, ix); err != nil {
+		log.Printf("[search] %s: saving index: %v", key, err)
+	}
+	return ix, nil
+}
+
+// update adds the reviews just appended to an app, which now has rows rows.
+// An index that isn't built yet stays that way (first search builds it);
+// one that missed other writes is dropped and rebuilt on next use.
+func (si *searchIndexes) update(appID, country string, reviews []Review, rows int) {
+	if len(reviews) == 0 {
+		return
+	}
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	key := storeKey(appID, country)
+	ix := si.byKey[key]
+	if ix == nil {
+		if ix = si.load(key, langFor(country)); ix == nil {
+			return
+		}
+		si.byKey[key] = ix
+	}
+	switch ix.Rows {
+	case rows - len(reviews):
+	case rows:
+		// rebuilt after these rows were written: adding again is a no-op
+	default:
+		delete(si.byKey, key)
+		return
+	}
+	for _, r := range reviews {
+		ix.add(r)
+	}
+	ix.Rows = rows
+	ix.dirty = true
+	if time.Since(ix.saved) >= searchSaveEvery {
+		if err := si.save(key, ix); err != nil {
+			log.Printf("[search] %s: saving index: %v", key, err)
+		}
+	}
+}
+
+// drop forgets the index of an app (e.g. after a compaction)
+func (si *searchIndexes) drop(appID, country string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	key := storeKey(appID, country)
+	delete(si.byKey, key)
+	if si.dir != "" {
+		os.Remove(si.path(key))
+	}
+}
+
+// flush saves every index updated since its last save
+func (si *searchIndexes) flush() error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	var errs []error
+	for key, ix := range si.byKey {
+		if ix.dirty {
+			errs = append(errs, si.save(key, ix))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,377 @@
+package internal
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The reviews of an app are partitioned by month of submittedAt:
+//
+//	data/reviews/<appId>-<country>/2024-05.jsonl      (or .jsonl.gz once compressed)
+//	data/reviews/<appId>-<country>/manifest.json      min/max submittedAt per segment
+//
+// so a window query only opens the segments it overlaps.
+
+const manifestName = "manifest.json"
+
+type segment struct {
+	Name string    `json:"name"` // 2024-05.jsonl or 2024-05.jsonl.gz
+	Min  time.Time `json:"min"`
+	Max  time.Time `json:"max"`
+	Rows int       `json:"rows"`
+	Size int64     `json:"size"` // bytes on disk when the manifest was saved
+}
+
+func (g segment) month() string       { return strings.SplitN(g.Name, ".", 2)[0] }
+func (g segment) compressed() bool    { return strings.HasSuffix(g.Name, ".gz") }
+func segmentMonth(t time.Time) string { return t.UTC().Format("2006-01") }
+
+func (g segment) overlaps(from, to time.Time) bool {
+	return !g.Max.Before(from) && !g.Min.After(to)
+}
+
+// widen grows min/max to cover t
+func (g *segment) widen(t time.Time) {
+	if g.Rows == 0 || t.Before(g.Min) {
+		g.Min = t
+	}
+	if g.Rows == 0 || t.After(g.Max) {
+		g.Max = t
+	}
+}
+
+type manifest struct {
+	Segments []segment `json:"segments"` // oldest month first
+}
+
+func (m *manifest) find(month string) *segment {
+	for i := range m.Segments {
+		if m.Segments[i].month() == month {
+			return &m.Segments[i]
+		}
+	}
+	return nil
+}
+
+func (m *manifest) rows() int {
+	n := 0
+	for _, g := range m.Segments {
+		n += g.Rows
+	}
+	return n
+}
+
+func (m *manifest) sort() {
+	sort.Slice(m.Segments, func(i, j int) bool { return m.Segments[i].month() < m.Segments[j].month() })
+}
+
+// ReviewsDir is the segment directory of an app
+func (s *FileStore) ReviewsDir(appID, country string) string {
+	return filepath.Join(s.baseDir, "reviews", storeKey(appID, country))
+}
+
+// legacyFilePath: the single JSONL file used before segments
+func (s *FileStore) legacyFilePath(key string) string {
+	return filepath.Join(s.baseDir, "reviews", key+".jsonl")
+}
+
+func readManifest(dir string) (*manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, manifestName), err)
+	}
+	m.sort()
+	return &m, nil
+}
+
+// writeManifest: atomic and durable, like SaveState. Saved only after the
+// segments it describes are synced, so it never covers rows not on disk.
+func writeManifest(dir string, m *manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, manifestName), append(b, '\n'))
+}
+
+// loadManifests migrates the legacy flat files, then reads every manifest
+func (s *FileStore) loadManifests() error {
+	legacy, err := filepath.Glob(filepath.Join(s.baseDir, "reviews", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, f := range legacy {
+		if s.readOnly {
+			continue // read in place below
+		}
+		if err := s.migrateLegacyFile(strings.TrimSuffix(filepath.Base(f), ".jsonl")); err != nil {
+			return fmt.Errorf("migrating %s to segments: %w", f, err)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.baseDir, "reviews"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == "quarantine" || strings.HasSuffix(e.Name(), ".tmp") {
+			continue // .tmp: unfinished migration, redone above
+		}
+		dir := filepath.Join(s.baseDir, "reviews", e.Name())
+		m, err := readManifest(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			// crashed before the first manifest save: recovered below
+			m, err = &manifest{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.recoverSegments(dir, m); err != nil {
+			return fmt.Errorf("checking %s: %w", dir, err)
+		}
+		if len(m.Segments) > 0 {
+			s.manifests[e.Name()] = m
+		}
+	}
+	if s.readOnly {
+		return s.readLegacyFiles(legacy)
+	}
+	return nil
+}
+
+// readLegacyFiles serves the flat files not migrated yet as a single
+// segment each (read-only stores only: the others split them on open)
+func (s *FileStore) readLegacyFiles(files []string) error {
+	for _, f := range files {
+		key := strings.TrimSuffix(filepath.Base(f), ".jsonl")
+		if _, ok := s.manifests[key]; ok {
+			continue // already migrated, the flat file is a leftover
+		}
+		// segment names are relative to the segment directory
+		name := filepath.Join("..", key+".jsonl")
+		g, err := rescanSegment(f, name)
+		if err != nil {
+			return err
+		}
+		if g.Rows > 0 {
+			s.manifests[key] = &manifest{Segments: []segment{g}}
+		}
+	}
+	return nil
+}
+
+// migrateLegacyFile splits <key>.jsonl into monthly segments. The segments
+// are built in <key>.tmp/ and renamed into place before the flat file is
+// removed, so a crash at any point is retried cleanly on the next start.
+func (s *FileStore) migrateLegacyFile(key string) error {
+	src := s.legacyFilePath(key)
+	dir := filepath.Join(s.baseDir, "reviews", key)
+	if _, err := os.Stat(filepath.Join(dir, manifestName)); err == nil {
+		// segments already in place: the flat file is a leftover
+		return os.Remove(src)
+	}
+
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return err
+	}
+	m := &manifest{}
+	rows := []Review{}
+	corrupted := [][]byte{}
+	err := scanLines(src, func(line []byte) {
+		var r Review
+		if err := json.Unmarshal(line, &r); err != nil || r.ID == "" {
+			corrupted = append(corrupted, append([]byte(nil), line...))
+			return
+		}
+		rows = append(rows, r)
+	})
+	if err != nil {
+		return err
+	}
+	if err := appendToSegments(tmpDir, m, rows); err != nil {
+		return err
+	}
+	if err := writeManifest(tmpDir, m); err != nil {
+		return err
+	}
+	if len(corrupted) > 0 {
+		// no date to partition them by: straight to quarantine
+		if err := appendLines(s.quarantinePath(key), corrupted); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	log.Printf("[store] %s: split %d rows into %d monthly segments", key, len(rows), len(m.Segments))
+	return os.Remove(src)
+}
+
+// appendToSegments appends rows to the segment of their month (creating it
+// if needed) and updates m; the caller saves the manifest
+func appendToSegments(dir string, m *manifest, rows []Review) error {
+	byMonth := map[string][]Review{}
+	months := []string{}
+	for _, r := range rows {
+		mo := segmentMonth(r.SubmittedAt)
+		if _, ok := byMonth[mo]; !ok {
+			months = append(months, mo)
+		}
+		byMonth[mo] = append(byMonth[mo], r)
+	}
+	for _, mo := range months {
+		seg := m.find(mo)
+		if seg == nil {
+			m.Segments = append(m.Segments, segment{Name: mo + ".jsonl"})
+			seg = &m.Segments[len(m.Segments)-1]
+		}
+		size, err := appendSegment(filepath.Join(dir, seg.Name), seg.compressed(), byMonth[mo])
+		if err != nil {
+			return err
+		}
+		seg.Size = size
+		for _, r := range byMonth[mo] {
+			seg.widen(r.SubmittedAt)
+			seg.Rows++
+		}
+	}
+	m.sort()
+	return nil
+}
+
+// appendSegment appends JSONL rows and fsyncs; a compressed segment gets
+// one more gzip member (a multi-member gzip file still reads as a single
+// stream). Returns the new file size.
+func appendSegment(path string, compressed bool, rows []Review) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if compressed {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+	if err := writeRows(w, rows); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	return fi.Size(), f.Close()
+}
+
+// writeSegment replaces path with rows (tmp + fsync + rename)
+func writeSegment(path string, compressed bool, rows []Review) (int64, error) {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	size, err := appendSegment(tmp, compressed, rows)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, err
+	}
+	return size, syncDir(filepath.Dir(path))
+}
+
+func writeRows(w io.Writer, rows []Review) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range rows {
+		b, _ := json.Marshal(r)
+		if _, err := bw.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// scanLines calls fn for every non-empty line of a JSONL file (gzipped if
+// the name ends in .gz); a missing file has no lines
+func scanLines(path string, fn func(line []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil // empty file
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			fn(sc.Bytes())
+		}
+	}
+	return sc.Err()
+}
+
+// segmentsFor returns (a copy of) the segments of an app overlapping
+// from..to, oldest first. Caller holds filesMu.
+func (s *FileStore) segmentsFor(appID, country string, from, to time.Time) []segment {
+	m := s.manifests[storeKey(appID, country)]
+	if m == nil {
+		return nil
+	}
+	out := []segment{}
+	for _, g := range m.Segments {
+		if g.overlaps(from, to) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
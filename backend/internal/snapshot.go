@@ -0,0 +1,399 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A snapshot is a tar.gz of the store files (paths relative to the data
+// directory) followed by snapshotManifestName, which lists every file with
+// its size and sha256.
+const snapshotManifestName = "MANIFEST.json"
+
+type SnapshotManifest struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	Backend   string         `json:"backend"` // jsonl | sqlite
+	Files     []SnapshotFile `json:"files"`
+}
+
+type SnapshotFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Snapshotter is implemented by stores that can write a consistent archive
+// of themselves while running
+type Snapshotter interface {
+	Snapshot(w io.Writer) (*SnapshotManifest, error)
+}
+
+var (
+	_ Snapshotter = (*FileStore)(nil)
+	_ Snapshotter = (*SQLStore)(nil)
+)
+
+// snapshotWriter adds files to a tar.gz and records them for the manifest
+type snapshotWriter struct {
+	gz  *gzip.Writer
+	tw  *tar.Writer
+	man *SnapshotManifest
+}
+
+func newSnapshotWriter(w io.Writer, backend string) *snapshotWriter {
+	gz := gzip.NewWriter(w)
+	return &snapshotWriter{
+		gz:  gz,
+		tw:  tar.NewWriter(gz),
+		man: &SnapshotManifest{CreatedAt: time.Now().UTC(), Backend: backend, Files: []SnapshotFile{}},
+	}
+}
+
+// addFile copies the file at src into the archive as name
+func (sw *snapshotWriter) addFile(src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: fi.Size(), ModTime: fi.ModTime(), Typeflag: tar.TypeReg}
+	if err := sw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(sw.tw, h), f)
+	if err != nil {
+		return err
+	}
+	if n != fi.Size() {
+		return fmt.Errorf("%s changed while archiving it", src)
+	}
+	sw.man.Files = append(sw.man.Files, SnapshotFile{Path: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// close appends the manifest and flushes the archive
+func (sw *snapshotWriter) close() (*SnapshotManifest, error) {
+	b, err := json.MarshalIndent(sw.man, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	hdr := &tar.Header{Name: snapshotManifestName, Mode: 0o644, Size: int64(len(b)), ModTime: sw.man.CreatedAt, Typeflag: tar.TypeReg}
+	if err := sw.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if _, err := sw.tw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := sw.tw.Close(); err != nil {
+		return nil, err
+	}
+	return sw.man, sw.gz.Close()
+}
+
+// Snapshot archives state.json and every segment (quarantine included).
+// Appends, compaction and state saves wait while the files are copied aside,
+// not while the archive is compressed.
+func (s *FileStore) Snapshot(w io.Writer) (*SnapshotManifest, error) {
+	tmp, err := os.MkdirTemp("", "reviews-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	files, err := s.copyForSnapshot(tmp)
+	if err != nil {
+		return nil, err
+	}
+	sw := newSnapshotWriter(w, StorageJSONL)
+	for _, rel := range files {
+		if err := sw.addFile(filepath.Join(tmp, rel), filepath.ToSlash(rel)); err != nil {
+			return nil, err
+		}
+	}
+	return sw.close()
+}
+
+// copyForSnapshot copies state.json and the segments into dir under the
+// write locks (reads of the segments go on) and returns their paths
+// relative to the data directory
+func (s *FileStore) copyForSnapshot(dir string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+
+	files := []string{}
+	if err := copyFile(s.statePath, filepath.Join(dir, "state.json")); err == nil {
+		files = append(files, "state.json")
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	root := filepath.Join(s.baseDir, "reviews")
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasSuffix(p, ".tmp") {
+			if d.IsDir() {
+				return fs.SkipDir // <key>.tmp/: unfinished legacy migration
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.baseDir, p)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(p, filepath.Join(dir, rel)); err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// copyFile copies src to dst, creating the directories of dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Snapshot copies the database with VACUUM INTO (a consistent read, writers
+// are not blocked) and archives the copy
+func (s *SQLStore) Snapshot(w io.Writer) (*SnapshotManifest, error) {
+	tmp, err := os.MkdirTemp("", "reviews-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	dbCopy := filepath.Join(tmp, "reviews.db")
+	if _, err := s.db.Exec(`VACUUM INTO ?`, dbCopy); err != nil {
+		return nil, fmt.Errorf("sqlite copy: %w", err)
+	}
+	sw := newSnapshotWriter(w, StorageSQLite)
+	if err := sw.addFile(dbCopy, "reviews.db"); err != nil {
+		return nil, err
+	}
+	return sw.close()
+}
+
+// WriteSnapshot writes a snapshot of st into dir as snapshot-<time>.tar.gz
+// (tmp + rename) and returns its path
+func WriteSnapshot(st ReviewStore, dir string) (string, *SnapshotManifest, error) {
+	sn, ok := st.(Snapshotter)
+	if !ok {
+		return "", nil, errors.New("store does not support snapshots")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", nil, err
+	}
+	name := filepath.Join(dir, "snapshot-"+time.Now().UTC().Format("20060102T150405Z")+".tar.gz")
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", nil, err
+	}
+	man, err := sn.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", nil, err
+	}
+	return name, man, nil
+}
+
+// RestoreSnapshot replaces the store of cfg under baseDir with the archive.
+// Every file is extracted aside and checked against the manifest first; the
+// current data is then moved to pre-restore-<time>/, never deleted. Both
+// live next to the store (baseDir, or the directory of the SQLite database),
+// so that every move is a rename within one filesystem.
+// The server must not be running.
+func RestoreSnapshot(archive io.Reader, cfg *Config, baseDir string) (*SnapshotManifest, error) {
+	root := baseDir
+	if cfg.Storage.Backend == StorageSQLite {
+		root = filepath.Dir(cfg.Storage.Path)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	stage, err := os.MkdirTemp(root, "restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stage)
+
+	man, err := extractSnapshot(archive, stage)
+	if err != nil {
+		return nil, err
+	}
+	if man.Backend != cfg.Storage.Backend {
+		return nil, fmt.Errorf("snapshot is a %q store but storage.backend is %q", man.Backend, cfg.Storage.Backend)
+	}
+
+	// extracted file or dir -> the live one it replaces
+	type target struct{ staged, live string }
+	targets := []target{}
+	var searchDir string
+	switch man.Backend {
+	case StorageJSONL:
+		searchDir = filepath.Join(baseDir, "search")
+		targets = append(targets,
+			target{filepath.Join(stage, "reviews"), filepath.Join(baseDir, "reviews")},
+			target{filepath.Join(stage, "state.json"), filepath.Join(baseDir, "state.json")})
+	case StorageSQLite:
+		searchDir = filepath.Join(filepath.Dir(cfg.Storage.Path), "search")
+		targets = append(targets, target{filepath.Join(stage, "reviews.db"), cfg.Storage.Path})
+	default:
+		return nil, fmt.Errorf("unknown snapshot backend %q", man.Backend)
+	}
+	// search indexes are not archived: set aside, rebuilt from the restored data
+	targets = append(targets, target{filepath.Join(stage, "search"), searchDir})
+
+	aside := filepath.Join(root, "pre-restore-"+time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(aside, 0o755); err != nil {
+		return nil, err
+	}
+	for _, t := range targets {
+		live := []string{t.live}
+		if man.Backend == StorageSQLite {
+			live = append(live, t.live+"-wal", t.live+"-shm")
+		}
+		for _, p := range live {
+			if err := os.Rename(p, filepath.Join(aside, filepath.Base(p))); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if _, err := os.Stat(t.staged); errors.Is(err, os.ErrNotExist) {
+			continue // e.g. no state.json in the snapshot
+		}
+		if err := os.MkdirAll(filepath.Dir(t.live), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(t.staged, t.live); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("[restore] previous data moved to %s", aside)
+	return man, syncDir(root)
+}
+
+// extractSnapshot unpacks an archive into dir and verifies it against its manifest
+func extractSnapshot(archive io.Reader, dir string) (*SnapshotManifest, error) {
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	sums := map[string]SnapshotFile{}
+	var man *SnapshotManifest
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Name == snapshotManifestName {
+			man = &SnapshotManifest{}
+			if err := json.NewDecoder(tr).Decode(man); err != nil {
+				return nil, fmt.Errorf("snapshot manifest: %w", err)
+			}
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("snapshot: unsafe path %q", hdr.Name)
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, h), tr)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		sums[name] = SnapshotFile{Path: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+	if man == nil {
+		return nil, errors.New("snapshot has no " + snapshotManifestName)
+	}
+	for _, want := range man.Files {
+		got, ok := sums[path.Clean(want.Path)]
+		if !ok {
+			return nil, fmt.Errorf("snapshot: %s listed in the manifest but missing", want.Path)
+		}
+		if got.Size != want.Size || got.SHA256 != want.SHA256 {
+			return nil, fmt.Errorf("snapshot: checksum mismatch for %s", want.Path)
+		}
+		delete(sums, path.Clean(want.Path))
+	}
+	for name := range sums {
+		return nil, fmt.Errorf("snapshot: %s is not in the manifest", name)
+	}
+	return man, nil
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultSource is used for apps that don't set "source" in config
+const DefaultSource = "apple"
+
+// ReviewSource is a provider of customer reviews (Apple RSS, or anything else
+// able to serve reviews page by page). The poller only talks to this interface.
+type ReviewSource interface {
+	// Name is the identifier used in the "source" field of an app entry
+	Name() string
+	// Pages returns how many pages the source can serve for the app (1..n)
+	Pages(app AppConfig) int
+	// FetchPage fetches a single page (single attempt, no retry). cond holds
+	// the validators from the previous fetch of the same page (may be empty);
+	// sources without conditional GET support just ignore it. The caller waits
+	// for the shared throttle before calling; a source sending more than one
+	// request calls WaitThrottle(ctx) before each of the others.
+	FetchPage(ctx context.Context, app AppConfig, page int, cond PageValidator) (FeedPage, error)
+}
+
+// SourceFactory builds a source from the loaded config
+type SourceFactory func(cfg *Config) ReviewSource
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]SourceFactory{}
+)
+
+// RegisterSource makes a source available by name; call it from init()
+// (or before ParseConfig). Panics on duplicates, like database/sql drivers.
+func RegisterSource(name string, factory SourceFactory) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if factory == nil {
+		panic("internal: RegisterSource factory is nil")
+	}
+	if _, dup := sources[name]; dup {
+		panic("internal: RegisterSource called twice for " + name)
+	}
+	sources[name] = factory
+}
+
+// SourceNames lists the registered sources (sorted)
+func SourceNames() []string {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	names := make([]string, 0, len(sources))
+	for n := range sources {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sourceRegistered(name string) bool {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	_, ok := sources[name]
+	return ok
+}
+
+// buildSources instantiates every registered source for the given config
+func buildSources(cfg *Config) map[string]ReviewSource {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	out := make(map[string]ReviewSource, len(sources))
+	for name, factory := range sources {
+		out[name] = factory(cfg)
+	}
+	return out
+}
+
+// SourceName returns the configured source, or DefaultSource
+func (a AppConfig) SourceName() string {
+	if a.Source == "" {
+		return DefaultSource
+	}
+	return a.Source
+}
+
+func (m *Manager) sourceFor(app AppConfig) (ReviewSource, error) {
+	src, ok := m.sources[app.SourceName()]
+	if !ok {
+		return nil, fmt.Errorf("unknown review source %q", app.SourceName())
+	}
+	return src, nil
+}
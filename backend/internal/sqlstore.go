@@ -0,0 +1,494 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go SQLite driver, registers "sqlite"
+)
+
+// Storage backends selectable in config
+const (
+	StorageJSONL  = "jsonl"
+	StorageSQLite = "sqlite"
+)
+
+// OpenStore opens the backend selected by cfg.Storage under baseDir.
+// The first time the SQLite backend is used, the JSONL data is imported.
+func OpenStore(cfg *Config, baseDir string) (ReviewStore, error) {
+	if cfg.Storage.Backend != StorageSQLite {
+		fs, err := NewFileStore(baseDir)
+		if err != nil {
+			return nil, err
+		}
+		fs.compressAfter = time.Duration(cfg.Storage.CompressAfterDays) * 24 * time.Hour
+		return fs, nil
+	}
+	ss, err := NewSQLStore(cfg.Storage.Path)
+	if err != nil {
+		return nil, err
+	}
+	empty, err := ss.empty()
+	if err != nil {
+		ss.Close()
+		return nil, err
+	}
+	if empty {
+		// read-only: the JSONL files stay as they are, to switch back to
+		fs, err := OpenFileStoreReadOnly(baseDir)
+		if err == nil {
+			err = ss.importFileStore(fs)
+			fs.Close()
+		}
+		if err != nil {
+			ss.Close()
+			return nil, fmt.Errorf("importing JSONL store into %s: %w", cfg.Storage.Path, err)
+		}
+	}
+	return ss, nil
+}
+
+// SQLStore keeps reviews in an embedded SQLite database: every revision in
+// `reviews`, the latest one per id in `latest` (indexed for window queries)
+type SQLStore struct {
+	db     *sql.DB
+	search *searchIndexes
+}
+
+var _ ReviewStore = (*SQLStore)(nil)
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS reviews (
+	seq          INTEGER PRIMARY KEY AUTOINCREMENT,
+	app_id       TEXT NOT NULL,
+	country      TEXT NOT NULL,
+	id           TEXT NOT NULL,
+	revision     INTEGER NOT NULL,
+	submitted_at INTEGER NOT NULL, -- unix ms
+	data         TEXT NOT NULL     -- Review as JSON
+);
+CREATE INDEX IF NOT EXISTS reviews_by_id ON reviews(app_id, country, id);
+
+CREATE TABLE IF NOT EXISTS latest (
+	app_id       TEXT NOT NULL,
+	country      TEXT NOT NULL,
+	id           TEXT NOT NULL,
+	revision     INTEGER NOT NULL,
+	submitted_at INTEGER NOT NULL,
+	rating       INTEGER NOT NULL,
+	removed      INTEGER NOT NULL,
+	hash         TEXT NOT NULL,
+	data         TEXT NOT NULL,
+	PRIMARY KEY (app_id, country, id)
+);
+CREATE INDEX IF NOT EXISTS latest_by_time ON latest(app_id, country, submitted_at);
+CREATE INDEX IF NOT EXISTS latest_by_rating ON latest(rating);
+
+CREATE TABLE IF NOT EXISTS seen (
+	app_id  TEXT NOT NULL,
+	country TEXT NOT NULL,
+	id      TEXT NOT NULL,
+	PRIMARY KEY (app_id, country, id)
+);
+
+CREATE TABLE IF NOT EXISTS poll_state (
+	app_id    TEXT NOT NULL,
+	country   TEXT NOT NULL,
+	last_poll INTEGER NOT NULL,
+	PRIMARY KEY (app_id, country)
+);
+
+CREATE TABLE IF NOT EXISTS page_validators (
+	app_id        TEXT NOT NULL,
+	country       TEXT NOT NULL,
+	page          INTEGER NOT NULL,
+	format        TEXT NOT NULL,
+	etag          TEXT NOT NULL,
+	last_modified TEXT NOT NULL,
+	PRIMARY KEY (app_id, country, page)
+);
+
+CREATE TABLE IF NOT EXISTS backfill (
+	app_id  TEXT NOT NULL,
+	country TEXT NOT NULL,
+	page    INTEGER NOT NULL,
+	PRIMARY KEY (app_id, country)
+);
+`
+
+func NewSQLStore(path string) (*SQLStore, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer: serialize instead of hitting SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqlSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite schema: %w", err)
+	}
+	s := &SQLStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite migration: %w", err)
+	}
+	// next to the database: data/search/ with the default path
+	s.search = newSearchIndexes(filepath.Join(filepath.Dir(path), "search"), StorageSQLite, s)
+	return s, nil
+}
+
+// sqlSchemaVersion is kept in PRAGMA user_version.
+// 1: latest.hash no longer covers the app version
+const sqlSchemaVersion = 1
+
+// migrate brings a database of an older version up to sqlSchemaVersion
+func (s *SQLStore) migrate() error {
+	var v int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&v); err != nil {
+		return err
+	}
+	if v >= sqlSchemaVersion {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	type row struct{ appID, country, id, hash string }
+	rehash := []row{}
+	rows, err := tx.Query(`SELECT app_id, country, id, data FROM latest`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var rw row
+		var data string
+		var r Review
+		if err := rows.Scan(&rw.appID, &rw.country, &rw.id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		if json.Unmarshal([]byte(data), &r) != nil {
+			continue
+		}
+		rw.hash = reviewHash(r)
+		rehash = append(rehash, rw)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, rw := range rehash {
+		if _, err := tx.Exec(`UPDATE latest SET hash = ? WHERE app_id = ? AND country = ? AND id = ?`,
+			rw.hash, rw.appID, rw.country, rw.id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, sqlSchemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Close() error {
+	return errors.Join(s.search.flush(), s.db.Close())
+}
+
+func (s *SQLStore) empty() (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM poll_state`).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM reviews`).Scan(&n)
+	return n == 0, err
+}
+
+func (s *SQLStore) AppendReviews(appID, country string, reviews []Review, newIDs []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := appendTx(tx, appID, country, reviews, newIDs); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO poll_state(app_id, country, last_poll) VALUES(?, ?, ?)
+		ON CONFLICT(app_id, country) DO UPDATE SET last_poll = excluded.last_poll`,
+		appID, country, time.Now().UTC().UnixMilli()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(reviews) > 0 {
+		rows, err := s.storedRows(appID, country)
+		if err != nil {
+			return err
+		}
+		s.search.update(appID, country, reviews, rows)
+	}
+	return nil
+}
+
+func (s *SQLStore) storedRows(appID, country string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM reviews WHERE app_id = ? AND country = ?`, appID, country).Scan(&n)
+	return n, err
+}
+
+// Search queries the search index of an app
+func (s *SQLStore) Search(appID, country string, q *SearchQuery, limit int) ([]SearchHit, int, error) {
+	return s.search.search(appID, country, q, limit)
+}
+
+func appendTx(tx *sql.Tx, appID, country string, reviews []Review, newIDs []string) error {
+	for _, r := range reviews {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		ts := r.SubmittedAt.UnixMilli()
+		if _, err := tx.Exec(`INSERT INTO reviews(app_id, country, id, revision, submitted_at, data) VALUES(?, ?, ?, ?, ?, ?)`,
+			appID, country, r.ID, r.Revision, ts, string(b)); err != nil {
+			return err
+		}
+		// same rule as the JSONL store: higher revision wins, ties go to the last write
+		if _, err := tx.Exec(`INSERT INTO latest(app_id, country, id, revision, submitted_at, rating, removed, hash, data)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(app_id, country, id) DO UPDATE SET
+				revision = excluded.revision, submitted_at = excluded.submitted_at, rating = excluded.rating,
+				removed = excluded.removed, hash = excluded.hash, data = excluded.data
+			WHERE excluded.revision >= latest.revision`,
+			appID, country, r.ID, r.Revision, ts, r.Rating, r.Removed(), reviewHash(r), string(b)); err != nil {
+			return err
+		}
+	}
+	for _, id := range newIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO seen(app_id, country, id) VALUES(?, ?, ?)`, appID, country, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) ReadRecent(appID, country string, horizon time.Duration) ([]Review, error) {
+	now := time.Now().UTC()
+	return s.ReadWindow(appID, country, now.Add(-horizon), now)
+}
+
+func (s *SQLStore) ReadWindow(appID, country string, from, to time.Time) ([]Review, error) {
+	rows, err := s.db.Query(`SELECT data FROM latest
+		WHERE app_id = ? AND country = ? AND submitted_at BETWEEN ? AND ?
+		ORDER BY submitted_at DESC`,
+		appID, country, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	return scanReviewRows(rows)
+}
+
+func (s *SQLStore) History(appID, country, id string) ([]Review, error) {
+	// one row per revision: the last write of each
+	rows, err := s.db.Query(`SELECT data FROM reviews WHERE seq IN (
+			SELECT MAX(seq) FROM reviews WHERE app_id = ? AND country = ? AND id = ? GROUP BY revision
+		) ORDER BY revision`, appID, country, id)
+	if err != nil {
+		return nil, err
+	}
+	return scanReviewRows(rows)
+}
+
+func scanReviewRows(rows *sql.Rows) ([]Review, error) {
+	defer rows.Close()
+	out := []Review{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var r Review
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			continue // same tolerance as the JSONL store
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLStore) GetSeenSet(appID, country string) map[string]struct{} {
+	set := map[string]struct{}{}
+	rows, err := s.db.Query(`SELECT id FROM seen WHERE app_id = ? AND country = ?`, appID, country)
+	if err != nil {
+		log.Printf("[sqlstore] seen set %s-%s: %v", appID, country, err)
+		return set
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			set[id] = struct{}{}
+		}
+	}
+	return set
+}
+
+func (s *SQLStore) RevisionMarks(appID, country string) map[string]RevisionMark {
+	out := map[string]RevisionMark{}
+	rows, err := s.db.Query(`SELECT id, hash, revision, removed FROM latest WHERE app_id = ? AND country = ?`, appID, country)
+	if err != nil {
+		log.Printf("[sqlstore] revision marks %s-%s: %v", appID, country, err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var m RevisionMark
+		if rows.Scan(&id, &m.Hash, &m.Rev, &m.Removed) == nil {
+			out[id] = m
+		}
+	}
+	return out
+}
+
+func (s *SQLStore) LastPoll(appID, country string) (time.Time, bool) {
+	var ms int64
+	err := s.db.QueryRow(`SELECT last_poll FROM poll_state WHERE app_id = ? AND country = ?`, appID, country).Scan(&ms)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms).UTC(), true
+}
+
+func (s *SQLStore) PageValidator(appID, country string, page int) PageValidator {
+	var v PageValidator
+	err := s.db.QueryRow(`SELECT format, etag, last_modified FROM page_validators WHERE app_id = ? AND country = ? AND page = ?`,
+		appID, country, page).Scan(&v.Format, &v.ETag, &v.LastModified)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[sqlstore] page validator %s-%s-%d: %v", appID, country, page, err)
+	}
+	return v
+}
+
+func (s *SQLStore) SavePageValidators(appID, country string, byPage map[int]PageValidator) error {
+	if len(byPage) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveValidatorsTx(tx, appID, country, byPage); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func saveValidatorsTx(tx *sql.Tx, appID, country string, byPage map[int]PageValidator) error {
+	for page, v := range byPage {
+		var err error
+		if v.IsZero() {
+			_, err = tx.Exec(`DELETE FROM page_validators WHERE app_id = ? AND country = ? AND page = ?`, appID, country, page)
+		} else {
+			_, err = tx.Exec(`INSERT INTO page_validators(app_id, country, page, format, etag, last_modified) VALUES(?, ?, ?, ?, ?, ?)
+				ON CONFLICT(app_id, country, page) DO UPDATE SET
+					format = excluded.format, etag = excluded.etag, last_modified = excluded.last_modified`,
+				appID, country, page, v.Format, v.ETag, v.LastModified)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) BackfillProgress(appID, country string) int {
+	var page int
+	_ = s.db.QueryRow(`SELECT page FROM backfill WHERE app_id = ? AND country = ?`, appID, country).Scan(&page)
+	return page
+}
+
+func (s *SQLStore) SetBackfillProgress(appID, country string, page int) error {
+	if page <= 0 {
+		_, err := s.db.Exec(`DELETE FROM backfill WHERE app_id = ? AND country = ?`, appID, country)
+		return err
+	}
+	_, err := s.db.Exec(`INSERT INTO backfill(app_id, country, page) VALUES(?, ?, ?)
+		ON CONFLICT(app_id, country) DO UPDATE SET page = excluded.page`, appID, country, page)
+	return err
+}
+
+// importFileStore copies the JSONL store (every row, in file order) and its
+// state into an empty database, in a single transaction
+func (s *SQLStore) importFileStore(fs *FileStore) error {
+	keys, err := fs.storedKeys()
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	total := 0
+	for _, k := range keys {
+		appID, country, ok := splitStoreKey(k)
+		if !ok {
+			continue
+		}
+		rows := []Review{}
+		if err := fs.scanReviews(appID, country, func(r Review) { rows = append(rows, r) }); err != nil {
+			return err
+		}
+		seen := fs.GetSeenSet(appID, country)
+		ids := make([]string, 0, len(seen))
+		for id := range seen {
+			ids = append(ids, id)
+		}
+		if err := appendTx(tx, appID, country, rows, ids); err != nil {
+			return err
+		}
+		total += len(rows)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for k, ent := range fs.state.Entries {
+		appID, country, ok := splitStoreKey(k)
+		if !ok || ent.LastPoll.IsZero() {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO poll_state(app_id, country, last_poll) VALUES(?, ?, ?)`,
+			appID, country, ent.LastPoll.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	for k, page := range fs.state.Backfill {
+		appID, country, ok := splitStoreKey(k)
+		if !ok {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO backfill(app_id, country, page) VALUES(?, ?, ?)`, appID, country, page); err != nil {
+			return err
+		}
+	}
+	// validators are not imported: the first poll just downloads full pages
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[sqlstore] imported %d rows from %d JSONL files", total, len(keys))
+	return nil
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+)
+
+// Buckets of GET /stats (UTC; weeks start on Monday)
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+// statsDefaultSpan: the window of /stats without from
+const statsDefaultSpan = 30 * 24 * time.Hour
+
+// maxStatsBuckets keeps ?bucket=hour over years from building a huge answer
+const maxStatsBuckets = 10000
+
+// RatingStats: how many reviews, their average and how many per star
+type RatingStats struct {
+	Count     int         `json:"count"`
+	Average   *float64    `json:"average"`   // null without reviews
+	Histogram map[int]int `json:"histogram"` // "1".."5" -> reviews
+	sum       int
+}
+
+func newRatingStats() RatingStats {
+	return RatingStats{Histogram: map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}}
+}
+
+func (s *RatingStats) add(rating int) {
+	if rating < 1 || rating > 5 {
+		return
+	}
+	s.Count++
+	s.sum += rating
+	s.Histogram[rating]++
+	avg := math.Round(float64(s.sum)/float64(s.Count)*100) / 100
+	s.Average = &avg
+}
+
+type StatsBucket struct {
+	Start time.Time `json:"start"`
+	RatingStats
+}
+
+// RatingReport is the answer of GET /stats
+type RatingReport struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Bucket  string        `json:"bucket"`
+	Totals  RatingStats   `json:"totals"`
+	Buckets []StatsBucket `json:"buckets"` // every bucket of from..to, empty ones too
+}
+
+// statsQuery: the parameters of GET /stats, validated
+type statsQuery struct {
+	From, To time.Time
+	Bucket   string
+}
+
+func parseStatsQuery(q url.Values, now time.Time) (statsQuery, error) {
+	sq := statsQuery{Bucket: BucketDay}
+	if b := q.Get("bucket"); b != "" {
+		if b != BucketHour && b != BucketDay && b != BucketWeek {
+			return sq, fmt.Errorf("bucket must be %s, %s or %s", BucketHour, BucketDay, BucketWeek)
+		}
+		sq.Bucket = b
+	}
+	var err error
+	if sq.From, sq.To, err = parseRange(q, now, statsDefaultSpan); err != nil {
+		return sq, err
+	}
+	if n := len(bucketStarts(sq.From, sq.To, sq.Bucket, maxStatsBuckets+1)); n > maxStatsBuckets {
+		return sq, fmt.Errorf("from..to is more than %d %ss", maxStatsBuckets, sq.Bucket)
+	}
+	return sq, nil
+}
+
+// bucketStart: the start of the bucket t falls in
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case BucketHour:
+		return t.Truncate(time.Hour)
+	case BucketWeek:
+		d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketHour:
+		return t.Add(time.Hour)
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// bucketStarts lists the buckets from..to touches (at most limit)
+func bucketStarts(from, to time.Time, bucket string, limit int) []time.Time {
+	out := []time.Time{}
+	for t := bucketStart(from, bucket); !t.After(to) && len(out) < limit; t = nextBucket(t, bucket) {
+		out = append(out, t)
+	}
+	return out
+}
+
+// ratingReport aggregates the latest revision of reviews (removed ones
+// left out) into the buckets of sq
+func ratingReport(revs []Review, sq statsQuery) RatingReport {
+	rep := RatingReport{From: sq.From, To: sq.To, Bucket: sq.Bucket, Totals: newRatingStats(), Buckets: []StatsBucket{}}
+	at := map[time.Time]int{}
+	for _, t := range bucketStarts(sq.From, sq.To, sq.Bucket, maxStatsBuckets) {
+		at[t] = len(rep.Buckets)
+		rep.Buckets = append(rep.Buckets, StatsBucket{Start: t, RatingStats: newRatingStats()})
+	}
+	for _, r := range revs {
+		if r.Removed() || r.SubmittedAt.Before(sq.From) || r.SubmittedAt.After(sq.To) {
+			continue
+		}
+		i, ok := at[bucketStart(r.SubmittedAt, sq.Bucket)]
+		if !ok {
+			continue
+		}
+		rep.Buckets[i].add(r.Rating)
+		rep.Totals.add(r.Rating)
+	}
+	return rep
+}
@@ -1,31 +1,100 @@
 package internal
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// ReviewStore is the persistence used by the poller and the API.
+// FileStore (JSONL + state.json) is the default, SQLStore the embedded SQL one.
+type ReviewStore interface {
+	// AppendReviews stores reviews (new ones, edits, removal markers), marks
+	// newIDs as seen and updates lastPoll; nil reviews just touch lastPoll
+	AppendReviews(appID, country string, reviews []Review, newIDs []string) error
+	// ReadWindow returns the latest revision of the reviews with
+	// from <= submittedAt <= to, newest first
+	ReadWindow(appID, country string, from, to time.Time) ([]Review, error)
+	// ReadRecent is ReadWindow(now-horizon, now)
+	ReadRecent(appID, country string, horizon time.Duration) ([]Review, error)
+	// History returns every revision of a review, oldest first
+	History(appID, country, id string) ([]Review, error)
+
+	GetSeenSet(appID, country string) map[string]struct{}
+	RevisionMarks(appID, country string) map[string]RevisionMark
+	LastPoll(appID, country string) (time.Time, bool)
+
+	PageValidator(appID, country string, page int) PageValidator
+	SavePageValidators(appID, country string, byPage map[int]PageValidator) error
+	BackfillProgress(appID, country string) int
+	SetBackfillProgress(appID, country string, page int) error
+
+	Close() error
+}
+
+var _ ReviewStore = (*FileStore)(nil)
+
 type FileStore struct {
 	baseDir   string
 	statePath string
 
+	// segment files and manifests (key: appId-country): appends and
+	// compaction take it for writing, reads for reading. Lock order is
+	// mu -> filesMu: never take mu while holding filesMu.
+	filesMu   sync.RWMutex
+	manifests map[string]*manifest
+	// compaction gzips segments whose newest review is older than this (0 = never)
+	compressAfter time.Duration
+
 	mu    sync.Mutex
 	state *State
+	// key: appId-country, built from the JSONL on first use, then kept
+	// up to date by AppendReviews: the ids we stored, with their latest mark
+	index map[string]map[string]RevisionMark
+
+	search *searchIndexes
+
+	// opened with OpenFileStoreReadOnly: nothing on disk is changed
+	readOnly bool
 }
 
+// errReadOnly: a write to a store opened with OpenFileStoreReadOnly
+var errReadOnly = errors.New("store opened read-only")
+
 func NewFileStore(baseDir string) (*FileStore, error) {
+	return openFileStore(baseDir, false)
+}
+
+// OpenFileStoreReadOnly opens the JSONL store of baseDir without touching
+// it: legacy flat files are read in place instead of split, an unclean
+// shutdown is not repaired (torn rows are skipped on read) and every write
+// fails. For readers that may run next to the server, like the fake feed.
+func OpenFileStoreReadOnly(baseDir string) (*FileStore, error) {
+	return openFileStore(baseDir, true)
+}
+
+func openFileStore(baseDir string, readOnly bool) (*FileStore, error) {
 	fs := &FileStore{
 		baseDir:   baseDir,
 		statePath: filepath.Join(baseDir, "state.json"),
 		state:     &State{Entries: map[string]*StateEntry{}},
+		index:     map[string]map[string]RevisionMark{},
+		manifests: map[string]*manifest{},
+		readOnly:  readOnly,
+	}
+	searchDir := filepath.Join(baseDir, "search")
+	if readOnly {
+		searchDir = "" // indexes kept in memory only
 	}
+	fs.search = newSearchIndexes(searchDir, StorageJSONL, fs)
 	if err := fs.loadState(); err != nil {
 		// Se non esiste, va bene; altrimenti errore
 		var pathError *os.PathError
@@ -33,13 +102,30 @@ func NewFileStore(baseDir string) (*FileStore, error) {
 			return nil, err
 		}
 	}
+	if err := fs.loadManifests(); err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return fs, nil
+	}
+	if err := fs.migrateState(); err != nil {
+		return nil, err
+	}
 	return fs, nil
 }
 
+// allTime is a ReadRecent horizon that covers every stored review
+const allTime = time.Duration(math.MaxInt64)
+
 func storeKey(appID, country string) string { return fmt.Sprintf("%s-%s", appID, country) }
 
-func (s *FileStore) ReviewsFilePath(appID, country string) string {
-	return filepath.Join(s.baseDir, "reviews", fmt.Sprintf("%s-%s.jsonl", appID, country))
+// splitStoreKey is the inverse of storeKey (countries never contain '-')
+func splitStoreKey(k string) (appID, country string, ok bool) {
+	i := strings.LastIndexByte(k, '-')
+	if i <= 0 || i == len(k)-1 {
+		return "", "", false
+	}
+	return k[:i], k[i+1:], true
 }
 
 func (s *FileStore) loadState() error {
@@ -60,60 +146,80 @@ func (s *FileStore) loadState() error {
 }
 
 func (s *FileStore) SaveState() error {
-	tmp := s.statePath + ".tmp"
-	f, err := os.Create(tmp)
-	if err != nil {
-		return err
-	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(s.state); err != nil {
-		f.Close()
-		return err
+	if s.readOnly {
+		return errReadOnly
 	}
-	if err := f.Close(); err != nil {
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
 		return err
 	}
-	return os.Rename(tmp, s.statePath)
+	return writeFileAtomic(s.statePath, append(b, '\n'))
 }
 
 // Thread-safe accessors
 
-func (s *FileStore) GetSeenSet(appID, country string) map[string]struct{} {
+// migrateState drops the seen ids / revision marks that older versions kept
+// in state.json: the JSONL files already have them
+func (s *FileStore) migrateState() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	ent := s.state.Entries[storeKey(appID, country)]
-	set := map[string]struct{}{}
-	if ent != nil {
-		for _, id := range ent.SeenIDs {
-			set[id] = struct{}{}
+	migrated := 0
+	for k, ent := range s.state.Entries {
+		if ent.SeenIDs == nil && ent.Revisions == nil {
+			continue
 		}
+		log.Printf("[store] %s: dropping %d seen ids from state.json (now derived from the JSONL)", k, len(ent.SeenIDs))
+		ent.SeenIDs = nil
+		ent.Revisions = nil
+		migrated++
 	}
-	return set
+	if migrated == 0 {
+		return nil
+	}
+	return s.SaveState()
 }
 
-func (s *FileStore) AppendReviews(appID, country string, reviews []Review, newIDs []string) error {
-	// Append JSONL
-	path := s.ReviewsFilePath(appID, country)
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+// indexFor returns the index of an app, building it from the JSONL if
+// needed. Caller holds s.mu.
+func (s *FileStore) indexFor(appID, country string) map[string]RevisionMark {
+	k := storeKey(appID, country)
+	if idx, ok := s.index[k]; ok {
+		return idx
+	}
+	latest, err := s.latestRevisions(appID, country)
 	if err != nil {
-		return err
+		// don't cache: retry on the next call
+		log.Printf("[store] %s: building index: %v", k, err)
+		return map[string]RevisionMark{}
 	}
-	w := bufio.NewWriter(f)
-	for _, r := range reviews {
-		b, _ := json.Marshal(r)
-		if _, err := w.Write(append(b, '\n')); err != nil {
-			f.Close()
-			return err
-		}
+	idx := make(map[string]RevisionMark, len(latest))
+	for id, r := range latest {
+		idx[id] = markOf(r)
 	}
-	if err := w.Flush(); err != nil {
-		f.Close()
-		return err
+	s.index[k] = idx
+	return idx
+}
+
+// GetSeenSet returns the ids stored for an app
+func (s *FileStore) GetSeenSet(appID, country string) map[string]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.indexFor(appID, country)
+	set := make(map[string]struct{}, len(idx))
+	for id := range idx {
+		set[id] = struct{}{}
 	}
-	if err := f.Close(); err != nil {
+	return set
+}
+
+// AppendReviews appends to the monthly segments and updates lastPoll; newIDs
+// need no bookkeeping here since every stored id is seen
+func (s *FileStore) AppendReviews(appID, country string, reviews []Review, newIDs []string) error {
+	rows, err := s.appendSegments(appID, country, reviews)
+	if err != nil {
 		return err
 	}
+	s.search.update(appID, country, reviews, rows)
 
 	// Update state
 	s.mu.Lock()
@@ -124,49 +230,131 @@ func (s *FileStore) AppendReviews(appID, country string, reviews []Review, newID
 		ent = &StateEntry{}
 	}
 	ent.LastPoll = time.Now().UTC()
-	// merge seenIds (append-only)
-	seen := map[string]struct{}{}
-	for _, id := range ent.SeenIDs {
-		seen[id] = struct{}{}
-	}
-	for _, id := range newIDs {
-		if _, ok := seen[id]; !ok {
-			ent.SeenIDs = append(ent.SeenIDs, id)
-			seen[id] = struct{}{}
+	s.state.Entries[k] = ent
+	// an index not built yet will read these rows from the file
+	if idx, ok := s.index[k]; ok {
+		for _, r := range reviews {
+			if cur, ok := idx[r.ID]; !ok || r.Revision >= cur.Rev {
+				idx[r.ID] = markOf(r)
+			}
 		}
 	}
-	s.state.Entries[k] = ent
 	return s.SaveState()
 }
 
+// appendSegments returns how many rows the app has now
+func (s *FileStore) appendSegments(appID, country string, reviews []Review) (int, error) {
+	if len(reviews) == 0 {
+		return 0, nil
+	}
+	if s.readOnly {
+		return 0, errReadOnly
+	}
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+	k := storeKey(appID, country)
+	dir := s.ReviewsDir(appID, country)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	m := s.manifests[k]
+	if m == nil {
+		m = &manifest{}
+		s.manifests[k] = m
+	}
+	if err := appendToSegments(dir, m, reviews); err != nil {
+		return 0, err
+	}
+	return m.rows(), writeManifest(dir, m)
+}
+
+func (s *FileStore) storedRows(appID, country string) (int, error) {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+	if m := s.manifests[storeKey(appID, country)]; m != nil {
+		return m.rows(), nil
+	}
+	return 0, nil
+}
+
+// Search queries the search index of an app
+func (s *FileStore) Search(appID, country string, q *SearchQuery, limit int) ([]SearchHit, int, error) {
+	return s.search.search(appID, country, q, limit)
+}
+
+// scanReviews calls fn for every decodable row of an app, oldest segment
+// first, in file order within a segment
+func (s *FileStore) scanReviews(appID, country string, fn func(r Review)) error {
+	return s.scanWindow(appID, country, time.Time{}, maxTime, fn)
+}
+
+// maxTime is later than any submittedAt
+var maxTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// scanWindow is scanReviews limited to the segments overlapping from..to
+func (s *FileStore) scanWindow(appID, country string, from, to time.Time, fn func(r Review)) error {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+	dir := s.ReviewsDir(appID, country)
+	for _, g := range s.segmentsFor(appID, country, from, to) {
+		err := scanLines(filepath.Join(dir, g.Name), func(line []byte) {
+			var r Review
+			if err := json.Unmarshal(line, &r); err != nil {
+				return // skip corrupted rows
+			}
+			fn(r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestRevisions collapses the rows of a file to the latest revision of each review
+func (s *FileStore) latestRevisions(appID, country string) (map[string]Review, error) {
+	latest := map[string]Review{}
+	err := s.scanReviews(appID, country, func(r Review) {
+		if cur, ok := latest[r.ID]; !ok || r.Revision >= cur.Revision {
+			latest[r.ID] = r
+		}
+	})
+	return latest, err
+}
+
+// ReadRecent returns the latest revision of the reviews submitted (or edited)
+// within horizon, newest first
 func (s *FileStore) ReadRecent(appID, country string, horizon time.Duration) ([]Review, error) {
-	path := s.ReviewsFilePath(appID, country)
-	f, err := os.Open(path)
-	if err != nil {
-		// file could not be there the first time we run the script
-		var pathErr *os.PathError
-		if errors.As(err, &pathErr) {
-			return []Review{}, nil
+	now := time.Now().UTC()
+	return s.ReadWindow(appID, country, now.Add(-horizon), now)
+}
+
+// ReadWindow only opens the segments overlapping from..to. An edit lands in
+// the segment of its own date, so a row is kept only if it is the latest
+// revision the index knows of.
+func (s *FileStore) ReadWindow(appID, country string, from, to time.Time) ([]Review, error) {
+	latest := map[string]Review{}
+	err := s.scanWindow(appID, country, from, to, func(r Review) {
+		if cur, ok := latest[r.ID]; !ok || r.Revision >= cur.Revision {
+			latest[r.ID] = r
 		}
+	})
+	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	cutoff := time.Now().UTC().Add(-horizon)
+	s.mu.Lock()
+	idx := s.indexFor(appID, country)
 	out := []Review{}
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		var r Review
-		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
-			continue // skip corrupted rows
+	for _, r := range latest {
+		if mk, ok := idx[r.ID]; ok && mk.Rev > r.Revision {
+			continue // superseded by an edit outside these segments
 		}
-		if r.SubmittedAt.After(cutoff) || r.SubmittedAt.Equal(cutoff) {
+		if !r.SubmittedAt.Before(from) && !r.SubmittedAt.After(to) {
 			out = append(out, r)
 		}
 	}
-	if err := sc.Err(); err != nil {
-		return nil, err
-	}
+	s.mu.Unlock()
 	// Sort (newest first)
 	sort.Slice(out, func(i, j int) bool {
 		return out[i].SubmittedAt.After(out[j].SubmittedAt)
@@ -175,6 +363,80 @@ func (s *FileStore) ReadRecent(appID, country string, horizon time.Duration) ([]
 	return out, nil
 }
 
+// Close saves the search indexes; every other write is already on disk
+func (s *FileStore) Close() error { return s.search.flush() }
+
+// storedKeys lists the appId-country pairs that have stored reviews
+func (s *FileStore) storedKeys() ([]string, error) {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+	keys := make([]string, 0, len(s.manifests))
+	for k := range s.manifests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func pageKey(appID, country string, page int) string {
+	return fmt.Sprintf("%s-%s-%d", appID, country, page)
+}
+
+// PageValidator returns the validators stored for a feed page (zero if none)
+func (s *FileStore) PageValidator(appID, country string, page int) PageValidator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Validators[pageKey(appID, country, page)]
+}
+
+// SavePageValidators stores validators by page number; call it only once the
+// reviews of those pages are persisted, or a 304 would hide them forever
+func (s *FileStore) SavePageValidators(appID, country string, byPage map[int]PageValidator) error {
+	if len(byPage) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.Validators == nil {
+		s.state.Validators = map[string]PageValidator{}
+	}
+	for page, v := range byPage {
+		k := pageKey(appID, country, page)
+		if v.IsZero() {
+			delete(s.state.Validators, k)
+			continue
+		}
+		s.state.Validators[k] = v
+	}
+	return s.SaveState()
+}
+
+// BackfillProgress returns the last page completed by an unfinished backfill (0 if none)
+func (s *FileStore) BackfillProgress(appID, country string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Backfill[storeKey(appID, country)]
+}
+
+// SetBackfillProgress records the last completed page; 0 clears it
+func (s *FileStore) SetBackfillProgress(appID, country string, page int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := storeKey(appID, country)
+	if page <= 0 {
+		if _, ok := s.state.Backfill[k]; !ok {
+			return nil
+		}
+		delete(s.state.Backfill, k)
+	} else {
+		if s.state.Backfill == nil {
+			s.state.Backfill = map[string]int{}
+		}
+		s.state.Backfill[k] = page
+	}
+	return s.SaveState()
+}
+
 func (s *FileStore) LastPoll(appID, country string) (time.Time, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AllCountries is the value of "countries" that expands to every storefront
+const AllCountries = "all"
+
+// appleStorefronts: App Store storefronts exposing the customer reviews RSS,
+// what "countries": "all" expands to
+var appleStorefronts = []string{
+	"ae", "af", "ag", "ai", "al", "am", "ao", "ar", "at", "au",
+	"az", "ba", "bb", "be", "bf", "bg", "bh", "bj", "bm", "bn",
+	"bo", "br", "bs", "bt", "bw", "by", "bz", "ca", "cd", "cg",
+	"ch", "ci", "cl", "cm", "cn", "co", "cr", "cv", "cy", "cz",
+	"de", "dk", "dm", "do", "dz", "ec", "ee", "eg", "es", "fi",
+	"fj", "fm", "fr", "ga", "gb", "gd", "ge", "gh", "gm", "gr",
+	"gt", "gw", "gy", "hk", "hn", "hr", "hu", "id", "ie", "il",
+	"in", "iq", "is", "it", "jm", "jo", "jp", "ke", "kg", "kh",
+	"kn", "kr", "kw", "ky", "kz", "la", "lb", "lc", "lk", "lr",
+	"lt", "lu", "lv", "ly", "ma", "md", "me", "mg", "mk", "ml",
+	"mm", "mn", "mo", "mr", "ms", "mt", "mu", "mv", "mw", "mx",
+	"my", "mz", "na", "ne", "ng", "ni", "nl", "no", "np", "nr",
+	"nz", "om", "pa", "pe", "pg", "ph", "pk", "pl", "pt", "pw",
+	"py", "qa", "ro", "rs", "ru", "rw", "sa", "sb", "sc", "se",
+	"sg", "si", "sk", "sl", "sn", "sr", "st", "sv", "sz", "tc",
+	"td", "th", "tj", "tm", "tn", "to", "tr", "tt", "tw", "tz",
+	"ua", "ug", "us", "uy", "uz", "vc", "ve", "vg", "vn", "vu",
+	"xk", "ye", "za", "zm", "zw",
+}
+
+// CountryList is "countries" on an app entry: either a list of storefront
+// codes or the string "all"
+type CountryList []string
+
+func (l *CountryList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if !strings.EqualFold(s, AllCountries) {
+			return fmt.Errorf("countries: expected a list or %q, got %q", AllCountries, s)
+		}
+		*l = append(CountryList(nil), appleStorefronts...)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("countries: expected a list or %q", AllCountries)
+	}
+	*l = list
+	return nil
+}
+
+var (
+	appIDPattern   = regexp.MustCompile(`^[0-9]+$`)
+	countryPattern = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// validKey checks the shape of an appId/country pair: it ends up in file
+// names (data/reviews/<appId>-<country>/) and in the feed URL
+func validKey(appID, country string) error {
+	if !appIDPattern.MatchString(appID) {
+		return fmt.Errorf("appId %q: expected digits", appID)
+	}
+	if !countryPattern.MatchString(country) {
+		return fmt.Errorf("country %q: expected a two-letter storefront code", country)
+	}
+	return nil
+}
+
+// expandApps turns every entry with "countries" into one entry per
+// storefront, so the manager keeps polling plain appId+country pairs
+func expandApps(apps []AppConfig) ([]AppConfig, error) {
+	out := []AppConfig{}
+	seen := map[string]bool{}
+	for _, a := range apps {
+		countries := []string{}
+		if a.Country != "" {
+			countries = append(countries, a.Country)
+		}
+		countries = append(countries, a.Countries...)
+		if a.AppID == "" || len(countries) == 0 {
+			return nil, fmt.Errorf("app entry %q: appId and country (or countries) are required", a.AppID)
+		}
+		mine := map[string]bool{}
+		for _, c := range countries {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if err := validKey(a.AppID, c); err != nil {
+				return nil, fmt.Errorf("app entry %q: %w", a.AppID, err)
+			}
+			if mine[c] {
+				continue // e.g. both in "country" and "countries"
+			}
+			mine[c] = true
+			k := storeKey(a.AppID, c)
+			if seen[k] {
+				return nil, fmt.Errorf("app %s listed twice", k)
+			}
+			seen[k] = true
+			e := a
+			e.Country = c
+			e.Countries = nil
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// Countries returns the configured storefronts of an app
+func (m *Manager) Countries(appID string) []string {
+	out := []string{}
+	for _, a := range m.cfg.Apps {
+		if a.AppID == appID {
+			out = append(out, a.Country)
+		}
+	}
+	return out
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRetryAfter caps what we accept from a Retry-After header
+const maxRetryAfter = time.Hour
+
+// errThrottled: the shared hold-off outlasts the caller's deadline
+var errThrottled = errors.New("outbound requests on hold (Retry-After)")
+
+// Throttle gates every outbound feed request of a Manager:
+//   - a token bucket (rps + burst) keeps the whole fleet of apps under
+//     Apple's throttling threshold, however many workers are polling;
+//   - when Apple answers 429/503 with Retry-After, all workers hold off,
+//     not only the failing page.
+type Throttle struct {
+	mu    sync.Mutex
+	until time.Time
+
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewThrottle(rps float64, burst int) *Throttle {
+	return &Throttle{
+		rate:   rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Defer pushes the hold-off to at least now+d
+func (t *Throttle) Defer(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if u := time.Now().Add(d); u.After(t.until) {
+		t.until = u
+	}
+}
+
+// Until returns the end of the current hold-off (zero/past if none)
+func (t *Throttle) Until() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.until
+}
+
+// reserve takes a token (possibly going into debt) and returns how long the
+// caller must wait before using it
+func (t *Throttle) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.tokens = min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+// Wait blocks until the hold-off is over and a request token is available.
+// It fails fast with errThrottled if a Retry-After hold-off would outlast
+// ctx, instead of sleeping into a timeout.
+func (t *Throttle) Wait(ctx context.Context) error {
+	if wait := time.Until(t.Until()); wait > 0 {
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) < wait {
+			return errThrottled
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+	}
+	if t.rate <= 0 {
+		return nil
+	}
+	return sleepCtx(ctx, t.reserve())
+}
+
+type throttleKey struct{}
+
+// withThrottle hands th to the source called with ctx, see WaitThrottle
+func withThrottle(ctx context.Context, th *Throttle) context.Context {
+	return context.WithValue(ctx, throttleKey{}, th)
+}
+
+// WaitThrottle is Throttle.Wait for a source that sends more than one request
+// per FetchPage: the caller only waits before the first one
+func WaitThrottle(ctx context.Context) error {
+	if th, ok := ctx.Value(throttleKey{}).(*Throttle); ok {
+		return th.Wait(ctx)
+	}
+	return nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryAfter reads a Retry-After value (delta-seconds or HTTP-date)
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	var d time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = t.Sub(now)
+	}
+	if d < 0 {
+		return 0
+	}
+	return min(d, maxRetryAfter)
+}
+
+// isRateLimited: Apple throttling (429) or temporary unavailability (503)
+func isRateLimited(err error) bool {
+	if errors.Is(err, errThrottled) {
+		return true
+	}
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.Status == http.StatusTooManyRequests || he.Status == http.StatusServiceUnavailable
+	}
+	return false
+}
@@ -2,14 +2,35 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"net/url"
+	"strings"
 	"time"
 )
 
 type AppConfig struct {
 	AppID   string `json:"appId"`
 	Country string `json:"country"`
-	Name    string `json:"name,omitempty"` // NEW: optional
+	// Countries fans the entry out to several storefronts (["us","gb"] or
+	// "all"); ParseConfig expands it into one entry per country
+	Countries CountryList `json:"countries,omitempty"`
+	Name      string      `json:"name,omitempty"`   // NEW: optional
+	Source    string      `json:"source,omitempty"` // review provider, default "apple"
+	Format    string      `json:"format,omitempty"` // apple feed: json | xml | auto (default)
+
+	// optional overrides of the global policies (unset fields inherit)
+	Retry         *RetryPolicy `json:"retry,omitempty"`
+	Poll          *PollPolicy  `json:"poll,omitempty"`
+	RetentionDays *int         `json:"retentionDays,omitempty"`
+}
+
+// FeedFormat returns the configured feed format, or FormatAuto
+func (a AppConfig) FeedFormat() string {
+	if a.Format == "" {
+		return FormatAuto
+	}
+	return a.Format
 }
 
 type CircuitBreakerConfig struct {
@@ -17,11 +38,76 @@ type CircuitBreakerConfig struct {
 	OpenCooldownSeconds int `json:"openCooldownSeconds"` // default 60
 }
 
+// RetryPolicy: per-page retries in FetchPageWithRetry
+type RetryPolicy struct {
+	Attempts    *int `json:"attempts,omitempty"`    // default 3 (1 + 2 retries)
+	BaseDelayMs *int `json:"baseDelayMs,omitempty"` // default 500, doubled at each retry
+	MaxJitterMs *int `json:"maxJitterMs,omitempty"` // default 200 (random 0..max added)
+}
+
+// PollPolicy: page walking in PollOnce
+type PollPolicy struct {
+	PageTimeoutSeconds *int `json:"pageTimeoutSeconds,omitempty"` // default 15, retries included
+	PageDelayMs        *int `json:"pageDelayMs,omitempty"`        // default 300, pause between pages
+	MaxPages           *int `json:"maxPages,omitempty"`           // default 10, capped by the source
+}
+
+// RateLimitConfig: outbound requests shared by all poll workers
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"` // default 2
+	Burst             int     `json:"burst"`             // default 5
+}
+
+// StorageConfig selects the review store
+type StorageConfig struct {
+	Backend string `json:"backend"` // "jsonl" (default) or "sqlite"
+	Path    string `json:"path"`    // sqlite database file, default data/reviews.db
+	// jsonl: compaction gzips monthly segments whose newest review is older
+	// than this (0 = never)
+	CompressAfterDays int `json:"compressAfterDays"`
+}
+
+// AnomalyConfig tunes the rating-drop detector run after each poll
+type AnomalyConfig struct {
+	Disabled     bool    `json:"disabled"`
+	WindowHours  int     `json:"windowHours"`  // recent window, default 24
+	BaselineDays int     `json:"baselineDays"` // history before it, default 28
+	ZThreshold   float64 `json:"zThreshold"`   // default 3
+	MinReviews   int     `json:"minReviews"`   // reviews the window needs to be judged, default 5
+	Samples      int     `json:"samples"`      // reviews attached to an alert, default 5
+}
+
+// WebhookSubscription: events posted to URL, optionally only for some apps
+// and (review.created) some reviews
+type WebhookSubscription struct {
+	URL       string   `json:"url"`
+	Events    []string `json:"events,omitempty"`    // default ["review.created"]
+	AppID     string   `json:"appId,omitempty"`     // "" = every app
+	Country   string   `json:"country,omitempty"`   // "" = every storefront
+	MaxRating int      `json:"maxRating,omitempty"` // only reviews rated up to this (0 = any)
+	Keywords  []string `json:"keywords,omitempty"`  // only reviews whose title or content has one of them
+}
+
+// DefaultFeedBaseURL is the public iTunes host serving the reviews RSS
+const DefaultFeedBaseURL = "https://itunes.apple.com"
+
 type Config struct {
-	PollIntervalMinutes int                  `json:"pollIntervalMinutes"`
-	WebhookURL          string               `json:"webhookUrl"` // could be empty (disabled)
-	CircuitBreaker      CircuitBreakerConfig `json:"circuitBreaker"`
-	Apps                []AppConfig          `json:"apps"`
+	PollIntervalMinutes  int                   `json:"pollIntervalMinutes"`
+	WebhookURL           string                `json:"webhookUrl"`  // could be empty (disabled)
+	Webhooks             []WebhookSubscription `json:"webhooks"`    // review.created / rating.anomaly subscriptions
+	FeedBaseURL          string                `json:"feedBaseUrl"` // default https://itunes.apple.com
+	CircuitBreaker       CircuitBreakerConfig  `json:"circuitBreaker"`
+	RateLimit            RateLimitConfig       `json:"rateLimit"`
+	MaxConcurrentPolls   int                   `json:"maxConcurrentPolls"` // default 4
+	Storage              StorageConfig         `json:"storage"`
+	RetentionDays        int                   `json:"retentionDays"`        // 0 = keep every review
+	CompactIntervalHours int                   `json:"compactIntervalHours"` // 0 = no scheduled compaction
+	BackupDir            string                `json:"backupDir"`            // POST /admin/snapshot target, default backups
+	AdminToken           string                `json:"adminToken"`           // bearer token of /admin/*; "" = local clients only
+	Anomaly              AnomalyConfig         `json:"anomaly"`
+	Retry                RetryPolicy           `json:"retry"`
+	Poll                 PollPolicy            `json:"poll"`
+	Apps                 []AppConfig           `json:"apps"`
 }
 
 func ParseConfig(r io.Reader) (*Config, error) {
@@ -32,12 +118,77 @@ func ParseConfig(r io.Reader) (*Config, error) {
 	if c.PollIntervalMinutes <= 0 {
 		c.PollIntervalMinutes = 15
 	}
+	if c.FeedBaseURL == "" {
+		c.FeedBaseURL = DefaultFeedBaseURL
+	}
+	u, err := url.Parse(c.FeedBaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid feedBaseUrl %q", c.FeedBaseURL)
+	}
+	c.FeedBaseURL = strings.TrimRight(c.FeedBaseURL, "/")
 	if c.CircuitBreaker.FailureThreshold <= 0 {
 		c.CircuitBreaker.FailureThreshold = 3
 	}
 	if c.CircuitBreaker.OpenCooldownSeconds <= 0 {
 		c.CircuitBreaker.OpenCooldownSeconds = 60
 	}
+	if c.RateLimit.RequestsPerSecond < 0 || c.RateLimit.Burst < 0 || c.MaxConcurrentPolls < 0 {
+		return nil, fmt.Errorf("rateLimit and maxConcurrentPolls must not be negative")
+	}
+	if c.RateLimit.RequestsPerSecond == 0 {
+		c.RateLimit.RequestsPerSecond = 2
+	}
+	if c.RateLimit.Burst == 0 {
+		c.RateLimit.Burst = 5
+	}
+	if c.RetentionDays < 0 || c.CompactIntervalHours < 0 {
+		return nil, fmt.Errorf("retentionDays and compactIntervalHours must not be negative")
+	}
+	if c.BackupDir == "" {
+		c.BackupDir = "backups"
+	}
+	if c.MaxConcurrentPolls == 0 {
+		c.MaxConcurrentPolls = 4
+	}
+	if err := c.Anomaly.applyDefaults(); err != nil {
+		return nil, err
+	}
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].validate(); err != nil {
+			return nil, fmt.Errorf("webhooks[%d]: %w", i, err)
+		}
+	}
+	switch c.Storage.Backend {
+	case "":
+		c.Storage.Backend = StorageJSONL
+	case StorageJSONL, StorageSQLite:
+	default:
+		return nil, fmt.Errorf("storage.backend must be %q or %q, got %q", StorageJSONL, StorageSQLite, c.Storage.Backend)
+	}
+	if c.Storage.CompressAfterDays < 0 {
+		return nil, fmt.Errorf("storage.compressAfterDays must not be negative")
+	}
+	if c.Storage.Backend == StorageSQLite && c.Storage.Path == "" {
+		c.Storage.Path = "data/reviews.db"
+	}
+	apps, err := expandApps(c.Apps)
+	if err != nil {
+		return nil, err
+	}
+	c.Apps = apps
+	for _, a := range c.Apps {
+		if !sourceRegistered(a.SourceName()) {
+			return nil, fmt.Errorf("app %s-%s: unknown source %q (available: %v)", a.AppID, a.Country, a.Source, SourceNames())
+		}
+		switch a.FeedFormat() {
+		case FormatJSON, FormatXML, FormatAuto:
+		default:
+			return nil, fmt.Errorf("app %s-%s: invalid format %q (json, xml or auto)", a.AppID, a.Country, a.Format)
+		}
+	}
+	if err := c.applyPolicyDefaults(); err != nil {
+		return nil, err
+	}
 	return &c, nil
 }
 
@@ -46,18 +197,50 @@ type Review struct {
 	AppID       string    `json:"appId"`
 	Country     string    `json:"country"`
 	Author      string    `json:"author"`
+	AuthorURI   string    `json:"authorUri,omitempty"`
 	Rating      int       `json:"rating"`
 	Title       string    `json:"title"`
 	Content     string    `json:"content"`
-	SubmittedAt time.Time `json:"submittedAt"` // UTC
+	ContentType string    `json:"contentType,omitempty"` // "text" / "html"
+	AppVersion  string    `json:"appVersion,omitempty"`  // im:version, app release reviewed
+	VoteSum     int       `json:"voteSum,omitempty"`     // "helpful" votes
+	VoteCount   int       `json:"voteCount,omitempty"`   // total votes
+	Link        string    `json:"link,omitempty"`        // review page on the App Store
+	SubmittedAt time.Time `json:"submittedAt"`           // UTC
+	Revision    int       `json:"revision,omitempty"`    // 0 = as first seen, +1 at each edit
+	RemovedAt   time.Time `json:"removedAt,omitzero"`    // set once the review vanished from the feed
+	Origin      string    `json:"origin,omitempty"`      // "" = the feed, OriginImport = an import
 }
 
+// OriginImport marks imported rows: not in the feed, so never reconciled with it
+const OriginImport = "import"
+
+func (r Review) Removed() bool { return !r.RemovedAt.IsZero() }
+
 type StateEntry struct {
-	SeenIDs  []string  `json:"seenIds"`
 	LastPoll time.Time `json:"lastPoll"`
+
+	// legacy: seen ids and revision marks used to live here and grew with
+	// every review; they are now derived from the JSONL (see FileStore.index)
+	// and dropped from state.json on load
+	SeenIDs   []string                `json:"seenIds,omitempty"`
+	Revisions map[string]RevisionMark `json:"revisions,omitempty"`
 }
 
+// PageValidator holds the HTTP cache validators of a feed page
+type PageValidator struct {
+	Format       string `json:"format"` // URL variant the validators belong to
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func (v PageValidator) IsZero() bool { return v.ETag == "" && v.LastModified == "" }
+
 type State struct {
 	// key: appId-country
 	Entries map[string]*StateEntry `json:"entries"`
+	// key: appId-country-page
+	Validators map[string]PageValidator `json:"validators,omitempty"`
+	// key: appId-country, value: last page completed by an unfinished backfill
+	Backfill map[string]int `json:"backfill,omitempty"`
 }
@@ -3,7 +3,13 @@ package internal
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -14,15 +20,190 @@ type webhookPayload struct {
 }
 
 func NotifyWebhook(url, id, errType string) error {
-	if url == "" {
-		return nil // webhook disabled
-	}
-	body := webhookPayload{
+	return postWebhook(url, webhookPayload{
 		ID:        id,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		ErrorType: errType,
+	})
+}
+
+// Webhook events besides failures (which carry errorType and no event)
+const (
+	EventReviewCreated = "review.created"
+	EventRatingAnomaly = "rating.anomaly"
+)
+
+// maxEventReviews caps the reviews of one review.created event (count has them all)
+const maxEventReviews = 100
+
+// Events are posted by a few workers off the poll path, so a slow or dead
+// endpoint doesn't hold a poll slot
+const (
+	webhookWorkers   = 4
+	webhookQueueSize = 256              // events waiting; more are dropped
+	webhookDrain     = 10 * time.Second // on Stop, for what is still queued
+)
+
+type webhookPost struct {
+	url   string
+	event string // for logs, e.g. "rating.anomaly 595068606-us"
+	body  any
+}
+
+// enqueueWebhook hands an event to the workers without waiting
+func (m *Manager) enqueueWebhook(url, event string, body any) {
+	select {
+	case m.hooks <- webhookPost{url: url, event: event, body: body}:
+	default:
+		log.Printf("[webhook] queue full, dropping %s to %s", event, url)
+	}
+}
+
+func (m *Manager) webhookWorker() {
+	defer m.hooksWG.Done()
+	send := func(p webhookPost) {
+		if err := postWebhook(p.url, p.body); err != nil {
+			log.Printf("[webhook] %s to %s: %v", p.event, p.url, err)
+		}
+	}
+	for {
+		select {
+		case p := <-m.hooks:
+			send(p)
+		case <-m.hooksStop:
+			deadline := time.Now().Add(webhookDrain)
+			for time.Now().Before(deadline) {
+				select {
+				case p := <-m.hooks:
+					send(p)
+				default:
+					return
+				}
+			}
+			if n := len(m.hooks); n > 0 {
+				log.Printf("[webhook] shutting down, %d events not sent", n)
+			}
+			return
+		}
+	}
+}
+
+// ReviewsEvent is the review.created event: the new reviews of one poll
+type ReviewsEvent struct {
+	Event     string   `json:"event"`
+	ID        string   `json:"id"` // appId-country
+	AppID     string   `json:"appId"`
+	Country   string   `json:"country"`
+	Timestamp string   `json:"timestamp"`
+	Count     int      `json:"count"`
+	Truncated bool     `json:"truncated,omitempty"` // more than maxEventReviews: only the newest are listed
+	Reviews   []Review `json:"reviews"`             // newest first
+}
+
+func (s *WebhookSubscription) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", s.URL)
+	}
+	if len(s.Events) == 0 {
+		s.Events = []string{EventReviewCreated}
+	}
+	for _, e := range s.Events {
+		if e != EventReviewCreated && e != EventRatingAnomaly {
+			return fmt.Errorf("unknown event %q (%s or %s)", e, EventReviewCreated, EventRatingAnomaly)
+		}
+	}
+	if s.MaxRating < 0 || s.MaxRating > 5 {
+		return errors.New("maxRating must be 1..5 (or 0 for any)")
+	}
+	for _, k := range s.Keywords {
+		if len(terms(k, LangEnglish)) == 0 {
+			return fmt.Errorf("keyword %q has nothing to match", k)
+		}
+	}
+	return nil
+}
+
+// wants: the subscription takes event for this app
+func (s WebhookSubscription) wants(event, appID, country string) bool {
+	return slices.Contains(s.Events, event) &&
+		(s.AppID == "" || s.AppID == appID) &&
+		(s.Country == "" || strings.EqualFold(s.Country, country))
+}
+
+// matches: r passes maxRating and keywords. Keywords go through the analysis
+// of the search index, so "crash" also matches "Crashes" and a keyword of
+// several words has to appear as is.
+func (s WebhookSubscription) matches(r Review) bool {
+	if s.MaxRating > 0 && r.Rating > s.MaxRating {
+		return false
+	}
+	if len(s.Keywords) == 0 {
+		return true
+	}
+	lang := langFor(r.Country)
+	text := append(terms(r.Title, lang), "")
+	text = append(text, terms(r.Content, lang)...)
+	for _, k := range s.Keywords {
+		if containsRun(text, terms(k, lang)) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsRun: words appears in text, in a row
+func containsRun(text, words []string) bool {
+	for i := 0; i+len(words) <= len(text); i++ {
+		if slices.Equal(text[i:i+len(words)], words) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyReviewsCreated queues the new reviews of a poll for every
+// subscription that wants some of them, in one event per subscription
+func (m *Manager) notifyReviewsCreated(app AppConfig, reviews []Review) {
+	k := storeKey(app.AppID, app.Country)
+	sorted := slices.Clone(reviews)
+	sortReviews(sorted, SortNewest)
+	for _, sub := range m.cfg.Webhooks {
+		if !sub.wants(EventReviewCreated, app.AppID, app.Country) {
+			continue
+		}
+		revs := []Review{}
+		for _, r := range sorted {
+			if sub.matches(r) {
+				revs = append(revs, r)
+			}
+		}
+		if len(revs) == 0 {
+			continue
+		}
+		ev := ReviewsEvent{
+			Event:     EventReviewCreated,
+			ID:        k,
+			AppID:     app.AppID,
+			Country:   app.Country,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Count:     len(revs),
+			Truncated: len(revs) > maxEventReviews,
+			Reviews:   revs[:min(len(revs), maxEventReviews)],
+		}
+		m.enqueueWebhook(sub.URL, EventReviewCreated+" "+k, ev)
+	}
+}
+
+// postWebhook POSTs body as JSON
+func postWebhook(url string, body any) error {
+	if url == "" {
+		return nil // webhook disabled
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
 	}
-	b, _ := json.Marshal(body)
 	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
 		return err
@@ -7,6 +7,14 @@ export type Review = {
     title: string;
     content: string;
     submittedAt: string; // ISO UTC
+    authorUri?: string;
+    contentType?: string;
+    appVersion?: string; // app release the review refers to
+    voteSum?: number;
+    voteCount?: number;
+    link?: string;
+    revision?: number; // > 0 when the author edited the review
+    removedAt?: string; // ISO UTC, set when the review vanished from the App Store
 };
 
 export type AppConfig = { appId: string; country: string, name?: string };